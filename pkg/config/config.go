@@ -0,0 +1,374 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mchmarny/rolesetter/pkg/role"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// APIVersion is the version of the configuration format.
+const APIVersion = "rolesetter/v1"
+
+// Config is the controller configuration, loaded from a YAML file and overridden by environment variables.
+// Zero values mean the controller default is used.
+type Config struct {
+	APIVersion     string         `json:"apiVersion"`
+	Sources        Sources        `json:"sources,omitempty"`
+	Mappings       Mappings       `json:"mappings,omitempty"`
+	Roles          Roles          `json:"roles,omitempty"`
+	Apply          Apply          `json:"apply,omitempty"`
+	Scope          Scope          `json:"scope,omitempty"`
+	Controller     Controller     `json:"controller,omitempty"`
+	Server         Server         `json:"server,omitempty"`
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+}
+
+// Sources are the node labels whose values become roles.
+type Sources struct {
+	Labels    []string `json:"labels,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Separator string   `json:"separator,omitempty"`
+	Trusted   Trusted  `json:"trusted,omitempty"`
+}
+
+// Trusted restricts the source labels that may drive roles to node-restriction.kubernetes.io/ ones,
+// or ones written under a trusted field manager name.
+type Trusted struct {
+	Enabled  bool     `json:"enabled,omitempty"`
+	Managers []string `json:"managers,omitempty"`
+}
+
+// Mappings are the CEL rules mapping nodes to roles, and how stale roles are handled.
+// With WatchResources, NodeRoleMapping resources are merged into the rules.
+type Mappings struct {
+	Rules          []Rule `json:"rules,omitempty"`
+	WatchResources bool   `json:"watchResources,omitempty"`
+	Replace        bool   `json:"replace,omitempty"`
+	GC             bool   `json:"gc,omitempty"`
+}
+
+// Rule maps a CEL expression evaluated against the Node to one or more roles.
+type Rule struct {
+	Name       string   `json:"name,omitempty"`
+	Expression string   `json:"expression"`
+	Roles      []string `json:"roles"`
+}
+
+// Roles configures how resolved role values are turned into role labels.
+type Roles struct {
+	Transforms []Transform `json:"transforms,omitempty"`
+	Default    string      `json:"default,omitempty"`
+	Normalize  Normalize   `json:"normalize,omitempty"`
+	Protected  Protected   `json:"protected,omitempty"`
+	// Taints are the taints applied to the nodes with each role, by role.
+	Taints map[string][]Taint `json:"taints,omitempty"`
+	// Metadata are the extra labels and annotations written to the nodes with each role, by role.
+	Metadata map[string]Metadata `json:"metadata,omitempty"`
+}
+
+// Metadata are the extra labels and annotations written to the nodes with a role.
+type Metadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Taint is a taint applied to the nodes with a role.
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect"`
+}
+
+// Transform is a step of the chain that turns source label values into roles.
+type Transform struct {
+	Regex       string            `json:"regex,omitempty"`
+	Aliases     map[string]string `json:"aliases,omitempty"`
+	StripPrefix string            `json:"stripPrefix,omitempty"`
+	StripSuffix string            `json:"stripSuffix,omitempty"`
+}
+
+// Normalize is the normalization applied to role values before they are validated as label names.
+type Normalize struct {
+	Lowercase   bool   `json:"lowercase,omitempty"`
+	Replacement string `json:"replacement,omitempty"`
+	Truncate    bool   `json:"truncate,omitempty"`
+}
+
+// Protected are the role patterns that are never added or removed.
+// A nil Deny denies the default protected roles; an empty one denies none.
+type Protected struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Apply configures how role labels are written to nodes.
+type Apply struct {
+	Outputs      []Output `json:"outputs,omitempty"`
+	FieldManager string   `json:"fieldManager,omitempty"`
+	Force        bool     `json:"force,omitempty"`
+	DryRun       bool     `json:"dryRun,omitempty"`
+	// StartupTaint is the taint nodes register with, removed once their roles are applied.
+	StartupTaint *StartupTaint `json:"startupTaint,omitempty"`
+	// Status records the outcome of each reconcile in the status annotation of the node.
+	Status Status `json:"status,omitempty"`
+}
+
+// Status records the outcome of each reconcile in the status annotation of the node.
+type Status struct {
+	Enabled bool `json:"enabled,omitempty"`
+	History int  `json:"history,omitempty"`
+}
+
+// StartupTaint is the taint nodes register with, removed once their roles are applied.
+type StartupTaint struct {
+	Key         string          `json:"key"`
+	Effect      string          `json:"effect"`
+	GracePeriod metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// Output is a label written for every resolved role, with Go templates for its key and value.
+type Output struct {
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// Scope limits the nodes the controller manages.
+type Scope struct {
+	NodeSelector string `json:"nodeSelector,omitempty"`
+}
+
+// Controller configures the reconcile workers.
+type Controller struct {
+	Workers int `json:"workers,omitempty"`
+}
+
+// Server configures the metrics and health server.
+type Server struct {
+	Port int `json:"port,omitempty"`
+}
+
+// LeaderElection configures the leader election Lease. It is enabled when Namespace is set.
+type LeaderElection struct {
+	Namespace     string          `json:"namespace,omitempty"`
+	LeaseName     string          `json:"leaseName,omitempty"`
+	Identity      string          `json:"identity,omitempty"`
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	RetryPeriod   metav1.Duration `json:"retryPeriod,omitempty"`
+}
+
+// Load reads the config file at path, if any, applies the environment variable
+// overrides read with getenv, and validates the result, reporting all errors found.
+func Load(path string, getenv func(string) string) (*Config, error) {
+	cfg := &Config{APIVersion: APIVersion}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if cfg, err = Parse(data); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(getenv); err != nil {
+		return nil, fmt.Errorf("invalid environment variables: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes a YAML config after validating it against the published JSON schema.
+func Parse(data []byte) (*Config, error) {
+	doc, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal(doc, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if err := validateSchema(raw); err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(doc, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks the values the schema can't, reporting all errors found.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.APIVersion != APIVersion {
+		errs = append(errs, fmt.Errorf("apiVersion: unsupported version %q, expected %q", c.APIVersion, APIVersion))
+	}
+	if len(c.Sources.Labels) == 0 && len(c.Mappings.Rules) == 0 && !c.Mappings.WatchResources {
+		errs = append(errs, fmt.Errorf("sources.labels, mappings.rules or mappings.watchResources must be specified"))
+	}
+	if c.Sources.Mode != "" {
+		if _, err := role.ParseSourceMode(c.Sources.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("sources.mode: %w", err))
+		}
+	}
+	if err := role.ValidateSeparator(c.Sources.Separator); err != nil {
+		errs = append(errs, fmt.Errorf("sources.separator: %w", err))
+	}
+	if err := role.ValidateRules(c.Rules()...); err != nil {
+		errs = append(errs, fmt.Errorf("mappings.rules: %w", err))
+	}
+	if err := role.ValidateTransforms(c.Transforms()...); err != nil {
+		errs = append(errs, fmt.Errorf("roles.transforms: %w", err))
+	}
+	if err := role.ValidateOutputs(c.Outputs()...); err != nil {
+		errs = append(errs, fmt.Errorf("apply.outputs: %w", err))
+	}
+	if err := c.Normalization().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("roles.normalize: %w", err))
+	}
+	if err := c.Startup().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("apply.startupTaint: %w", err))
+	}
+	if err := c.NodeStatus().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("apply.status: %w", err))
+	}
+	if err := c.Trust().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("sources.trusted: %w", err))
+	}
+	if err := role.ValidateMetadata(c.Metadata()); err != nil {
+		errs = append(errs, fmt.Errorf("roles.metadata: %w", err))
+	}
+	if err := role.ValidateTaints(c.Taints()); err != nil {
+		errs = append(errs, fmt.Errorf("roles.taints: %w", err))
+	}
+	if err := c.Protection().Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("roles.protected: %w", err))
+	}
+	if _, err := labels.Parse(c.Scope.NodeSelector); err != nil {
+		errs = append(errs, fmt.Errorf("scope.nodeSelector: %w", err))
+	}
+	if c.Controller.Workers < 0 {
+		errs = append(errs, fmt.Errorf("controller.workers must be a positive integer"))
+	}
+	if c.Server.Port < 0 {
+		errs = append(errs, fmt.Errorf("server.port must be a positive integer"))
+	}
+	return errors.Join(errs...)
+}
+
+// Rules returns the mapping rules, naming unnamed ones by their position.
+func (c *Config) Rules() []role.Rule {
+	rules := make([]role.Rule, 0, len(c.Mappings.Rules))
+	for i, r := range c.Mappings.Rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i+1)
+		}
+		rules = append(rules, role.Rule{
+			Name:       name,
+			Expression: r.Expression,
+			Roles:      r.Roles,
+		})
+	}
+	return rules
+}
+
+// Transforms returns the chain that turns source label values into roles.
+func (c *Config) Transforms() []role.Transform {
+	transforms := make([]role.Transform, 0, len(c.Roles.Transforms))
+	for _, t := range c.Roles.Transforms {
+		transforms = append(transforms, role.Transform{
+			Regex:       t.Regex,
+			Aliases:     t.Aliases,
+			StripPrefix: t.StripPrefix,
+			StripSuffix: t.StripSuffix,
+		})
+	}
+	return transforms
+}
+
+// Outputs returns the labels written for every resolved role.
+func (c *Config) Outputs() []role.Output {
+	outputs := make([]role.Output, 0, len(c.Apply.Outputs))
+	for _, o := range c.Apply.Outputs {
+		outputs = append(outputs, role.Output{Key: o.Key, Value: o.Value})
+	}
+	return outputs
+}
+
+// Normalization returns the normalization applied to role values.
+func (c *Config) Normalization() role.Normalization {
+	return role.Normalization{
+		Lowercase:   c.Roles.Normalize.Lowercase,
+		Replacement: c.Roles.Normalize.Replacement,
+		Truncate:    c.Roles.Normalize.Truncate,
+	}
+}
+
+// Protection returns the roles that are never added or removed.
+func (c *Config) Protection() role.Protection {
+	return role.Protection{
+		Allow: c.Roles.Protected.Allow,
+		Deny:  c.Roles.Protected.Deny,
+	}
+}
+
+// Metadata returns the extra labels and annotations written to the nodes with each role.
+func (c *Config) Metadata() map[string]role.Metadata {
+	metadata := make(map[string]role.Metadata, len(c.Roles.Metadata))
+	for r, md := range c.Roles.Metadata {
+		metadata[r] = role.Metadata{Labels: md.Labels, Annotations: md.Annotations}
+	}
+	return metadata
+}
+
+// Taints returns the taints applied to the nodes with each role.
+func (c *Config) Taints() map[string][]role.Taint {
+	taints := make(map[string][]role.Taint, len(c.Roles.Taints))
+	for r, ts := range c.Roles.Taints {
+		for _, t := range ts {
+			taints[r] = append(taints[r], role.Taint{Key: t.Key, Value: t.Value, Effect: corev1.TaintEffect(t.Effect)})
+		}
+	}
+	return taints
+}
+
+// Startup returns the taint removed from nodes once their roles are applied, the zero value when unset.
+func (c *Config) Startup() role.StartupTaint {
+	if c.Apply.StartupTaint == nil {
+		return role.StartupTaint{}
+	}
+	return role.StartupTaint{
+		Key:         c.Apply.StartupTaint.Key,
+		Effect:      corev1.TaintEffect(c.Apply.StartupTaint.Effect),
+		GracePeriod: c.Apply.StartupTaint.GracePeriod.Duration,
+	}
+}
+
+// NodeStatus returns how the outcome of each reconcile is recorded on the nodes.
+func (c *Config) NodeStatus() role.Status {
+	return role.Status{
+		Enabled: c.Apply.Status.Enabled,
+		History: c.Apply.Status.History,
+	}
+}
+
+// Trust returns which source labels are trusted to drive roles.
+func (c *Config) Trust() role.Trust {
+	return role.Trust{
+		Enabled:  c.Sources.Trusted.Enabled,
+		Managers: c.Sources.Trusted.Managers,
+	}
+}
@@ -0,0 +1,243 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const validConfig = `
+apiVersion: rolesetter/v1
+sources:
+  labels: [nodeGroup, karpenter.sh/nodepool]
+  mode: first
+  separator: "_"
+  trusted:
+    enabled: true
+    managers: [kubectl-label, karpenter]
+mappings:
+  rules:
+    - name: gpu
+      expression: node.status.allocatable['nvidia.com/gpu'] > 0
+      roles: [gpu]
+    - expression: "'ingress' in node.metadata.labels"
+      roles: [ingress, edge]
+  replace: true
+  gc: true
+roles:
+  transforms:
+    - stripPrefix: eks-
+    - regex: ^([a-z]+)-
+    - aliases: {gpu: accelerator}
+  default: worker
+  normalize:
+    lowercase: true
+    replacement: "-"
+    truncate: true
+  metadata:
+    gpu:
+      labels: {workload-class: accelerated}
+      annotations: {example.com/cost-center: ml}
+  taints:
+    gpu:
+      - key: dedicated
+        value: gpu
+        effect: NoSchedule
+  protected:
+    allow: ["gpu|cpu", "accelerator"]
+    deny: [control-plane, "master|infra-.*"]
+apply:
+  outputs:
+    - key: node-role.kubernetes.io/{{.Role}}
+      value: "true"
+    - key: kubernetes.io/role
+      value: "{{.Role}}"
+  fieldManager: rolesetter
+  dryRun: true
+  startupTaint:
+    key: rolesetter.io/unlabeled
+    effect: NoSchedule
+    gracePeriod: 10m
+scope:
+  nodeSelector: node-pool in (gpu, cpu)
+controller:
+  workers: 4
+server:
+  port: 8080
+leaderElection:
+  leaseName: gpu-role-controller
+  leaseDuration: 30s
+  renewDeadline: 20s
+  retryPeriod: 4s
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(validConfig))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+	if len(c.Sources.Labels) != 2 || c.Sources.Separator != "_" || !c.Mappings.GC || !c.Apply.DryRun || c.Controller.Workers != 4 {
+		t.Errorf("unexpected config: %+v", c)
+	}
+	if c.LeaderElection.LeaseDuration.Duration != 30*time.Second {
+		t.Errorf("expected 30s lease duration, got %s", c.LeaderElection.LeaseDuration.Duration)
+	}
+
+	if tr := c.Transforms(); len(tr) != 3 || tr[1].Regex != "^([a-z]+)-" || tr[2].Aliases["gpu"] != "accelerator" || c.Roles.Default != "worker" {
+		t.Errorf("unexpected transforms: %+v", tr)
+	}
+	if o := c.Outputs(); len(o) != 2 || o[0].Value != "true" || o[1].Key != "kubernetes.io/role" {
+		t.Errorf("unexpected outputs: %+v", o)
+	}
+	if n := c.Normalization(); !n.Lowercase || n.Replacement != "-" || !n.Truncate {
+		t.Errorf("unexpected normalization: %+v", n)
+	}
+	if s := c.Startup(); s.Key != "rolesetter.io/unlabeled" || s.Effect != corev1.TaintEffectNoSchedule || s.GracePeriod != 10*time.Minute {
+		t.Errorf("unexpected startup taint: %+v", s)
+	}
+	if tr := c.Trust(); !tr.Enabled || len(tr.Managers) != 2 || tr.Managers[0] != "kubectl-label" {
+		t.Errorf("unexpected trust: %+v", tr)
+	}
+	if md := c.Metadata()["gpu"]; md.Labels["workload-class"] != "accelerated" || md.Annotations["example.com/cost-center"] != "ml" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if ts := c.Taints()["gpu"]; len(ts) != 1 || ts[0].Value != "gpu" || ts[0].Effect != corev1.TaintEffectNoSchedule {
+		t.Errorf("unexpected taints: %+v", ts)
+	}
+	if p := c.Protection(); len(p.Allow) != 2 || len(p.Deny) != 2 || p.Deny[1] != "master|infra-.*" {
+		t.Errorf("unexpected protection: %+v", p)
+	}
+
+	rules := c.Rules()
+	if len(rules) != 2 || rules[0].Name != "gpu" || rules[1].Name != "rule-2" {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+}
+
+func TestParse_EmptyProtectedDeny(t *testing.T) {
+	// An explicitly empty deny list turns off the default protected roles
+	c, err := Parse([]byte("apiVersion: rolesetter/v1\nsources:\n  labels: [nodeGroup]\nroles:\n  protected:\n    deny: []\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d := c.Protection().Deny; d == nil || len(d) != 0 {
+		t.Errorf("expected an empty non-nil deny list, got %#v", d)
+	}
+}
+
+func TestParse_ReportsAllSchemaErrors(t *testing.T) {
+	_, err := Parse([]byte(`
+apiVersion: rolesetter/v2
+sources:
+  mode: some
+  extra: true
+  trusted:
+    managers: [kubelet]
+roles:
+  transforms:
+    - stripPrefix: eks-
+      stripSuffix: -xyz
+  normalize:
+    replacement: " "
+controller:
+  workers: 0
+leaderElection:
+  leaseDuration: 15
+`))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, want := range []string{"apiVersion", "sources.mode", "sources.extra", "sources.trusted.managers", "roles.transforms", "roles.normalize.replacement", "controller.workers", "leaderElection.leaseDuration"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr []string
+	}{
+		{
+			name: "valid",
+			cfg:  Config{APIVersion: APIVersion, Sources: Sources{Labels: []string{"nodeGroup"}}},
+		},
+		{
+			name:    "no sources",
+			cfg:     Config{APIVersion: APIVersion},
+			wantErr: []string{"sources.labels, mappings.rules or mappings.watchResources"},
+		},
+		{
+			name: "all errors",
+			cfg: Config{
+				APIVersion: "v0",
+				Sources:    Sources{Labels: []string{"nodeGroup"}, Mode: "some", Separator: ",", Trusted: Trusted{Managers: []string{"kubelet"}}},
+				Mappings:   Mappings{Rules: []Rule{{Expression: "node.", Roles: []string{"a"}}}},
+				Roles: Roles{
+					Transforms: []Transform{{Regex: "("}},
+					Normalize:  Normalize{Replacement: "/"},
+					Protected:  Protected{Deny: []string{"("}},
+					Taints:     map[string][]Taint{"gpu": {{Key: "dedicated", Effect: "Sometimes"}}},
+					Metadata:   map[string]Metadata{"gpu": {Labels: map[string]string{"bad key": ""}}},
+				},
+				Apply: Apply{Outputs: []Output{{Key: "{{.Name}}"}}, StartupTaint: &StartupTaint{Key: "rolesetter.io/unlabeled"}, Status: Status{History: 100}},
+				Scope: Scope{NodeSelector: "a in (b"},
+			},
+			wantErr: []string{"apiVersion", "sources.mode", "sources.separator", "sources.trusted", "mappings.rules", "roles.transforms", "roles.normalize", "roles.metadata", "roles.taints", "roles.protected", "apply.outputs", "apply.startupTaint", "apply.status", "scope.nodeSelector"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to mention %s, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(validConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{"WORKERS": "8"}
+	c, err := Load(path, func(k string) string { return env[k] })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Controller.Workers != 8 {
+		t.Errorf("expected env to override workers, got %d", c.Controller.Workers)
+	}
+
+	// Without a file, env vars alone are enough
+	env = map[string]string{"ROLE_LABEL": "nodeGroup"}
+	if _, err := Load("", func(k string) string { return env[k] }); err != nil {
+		t.Errorf("unexpected error without config file: %v", err)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml"), os.Getenv); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/role"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// applyEnv overrides the config with the environment variables that are set, reporting all errors found.
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var errs []error
+
+	if v := getenv("ROLE_LABEL"); v != "" {
+		c.Sources.Labels = splitList(v)
+	}
+	if v := getenv("ROLE_LABEL_MODE"); v != "" {
+		c.Sources.Mode = v
+	}
+	if v := getenv("ROLE_LABEL_SEPARATOR"); v != "" {
+		c.Sources.Separator = v
+	}
+	errs = append(errs, setBool(getenv, "ROLE_LABEL_TRUSTED", &c.Sources.Trusted.Enabled))
+	if v := getenv("ROLE_LABEL_TRUSTED_MANAGERS"); v != "" {
+		c.Sources.Trusted.Managers = splitList(v)
+	}
+	// Rules are one per line in the `<expression> -> <role>[,<role>...]` format
+	if v := getenv("ROLE_RULES"); v != "" {
+		rules, err := parseRules(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ROLE_RULES: %w", err))
+		}
+		c.Mappings.Rules = rules
+	}
+	errs = append(errs,
+		setBool(getenv, "WATCH_MAPPINGS", &c.Mappings.WatchResources),
+		setBool(getenv, "ROLE_LABEL_REPLACE", &c.Mappings.Replace),
+		setBool(getenv, "ROLE_LABEL_GC", &c.Mappings.GC),
+	)
+
+	if v := getenv("ROLE_DEFAULT"); v != "" {
+		c.Roles.Default = v
+	}
+	errs = append(errs, setBool(getenv, "ROLE_NORMALIZE_LOWERCASE", &c.Roles.Normalize.Lowercase))
+	if v := getenv("ROLE_NORMALIZE_REPLACEMENT"); v != "" {
+		c.Roles.Normalize.Replacement = v
+	}
+	errs = append(errs, setBool(getenv, "ROLE_NORMALIZE_TRUNCATE", &c.Roles.Normalize.Truncate))
+	// Extra labels and annotations are comma-separated `<role>=<key>=<value>` items
+	if v := getenv("ROLE_EXTRA_LABELS"); v != "" {
+		labels, err := parseRoleValues(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ROLE_EXTRA_LABELS: %w", err))
+		}
+		c.setMetadata(labels, func(md *Metadata) *map[string]string { return &md.Labels })
+	}
+	if v := getenv("ROLE_EXTRA_ANNOTATIONS"); v != "" {
+		annotations, err := parseRoleValues(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ROLE_EXTRA_ANNOTATIONS: %w", err))
+		}
+		c.setMetadata(annotations, func(md *Metadata) *map[string]string { return &md.Annotations })
+	}
+	// Taints are comma-separated `<role>=<key>[=<value>]:<effect>` items
+	if v := getenv("ROLE_TAINTS"); v != "" {
+		taints, err := parseTaints(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ROLE_TAINTS: %w", err))
+		}
+		c.Roles.Taints = taints
+	}
+	if v := getenv("ROLE_PROTECTED_ALLOW"); v != "" {
+		c.Roles.Protected.Allow = splitList(v)
+	}
+	if v := getenv("ROLE_PROTECTED_DENY"); v != "" {
+		c.Roles.Protected.Deny = splitList(v)
+	}
+
+	// Outputs are comma-separated `<key template>[=<value template>]` pairs
+	if v := getenv("ROLE_OUTPUTS"); v != "" {
+		c.Apply.Outputs = parseOutputs(v)
+	}
+	if v := getenv("FIELD_MANAGER"); v != "" {
+		c.Apply.FieldManager = v
+	}
+	errs = append(errs,
+		setBool(getenv, "FIELD_MANAGER_FORCE", &c.Apply.Force),
+		setBool(getenv, "DRY_RUN", &c.Apply.DryRun),
+		setBool(getenv, "STATUS_ANNOTATION", &c.Apply.Status.Enabled),
+	)
+	// The startup taint is `<key>:<effect>`
+	if v := getenv("STARTUP_TAINT"); v != "" {
+		key, effect, _ := strings.Cut(v, ":")
+		if c.Apply.StartupTaint == nil {
+			c.Apply.StartupTaint = &StartupTaint{}
+		}
+		c.Apply.StartupTaint.Key, c.Apply.StartupTaint.Effect = strings.TrimSpace(key), strings.TrimSpace(effect)
+	}
+	if v := getenv("STARTUP_TAINT_GRACE_PERIOD"); v != "" {
+		if c.Apply.StartupTaint == nil {
+			c.Apply.StartupTaint = &StartupTaint{}
+		}
+		errs = append(errs, setDuration(getenv, "STARTUP_TAINT_GRACE_PERIOD", &c.Apply.StartupTaint.GracePeriod))
+	}
+
+	if v := getenv("NODE_SELECTOR"); v != "" {
+		c.Scope.NodeSelector = v
+	}
+
+	errs = append(errs,
+		setInt(getenv, "STATUS_HISTORY", &c.Apply.Status.History),
+		setInt(getenv, "WORKERS", &c.Controller.Workers),
+		setInt(getenv, "SERVER_PORT", &c.Server.Port),
+	)
+
+	if v := getenv("NAMESPACE"); v != "" {
+		c.LeaderElection.Namespace = v
+	}
+	if v := getenv("LEASE_NAME"); v != "" {
+		c.LeaderElection.LeaseName = v
+	}
+	// The pod name from the downward API wins over the file identity, which every replica
+	// shares through the ConfigMap and would let them all hold the lease at once
+	if v := getenv("POD_NAME"); v != "" {
+		c.LeaderElection.Identity = v
+	}
+	errs = append(errs,
+		setDuration(getenv, "LEASE_DURATION", &c.LeaderElection.LeaseDuration),
+		setDuration(getenv, "LEASE_RENEW_DEADLINE", &c.LeaderElection.RenewDeadline),
+		setDuration(getenv, "LEASE_RETRY_PERIOD", &c.LeaderElection.RetryPeriod),
+	)
+
+	return errors.Join(errs...)
+}
+
+// parseOutputs parses comma-separated `<key template>[=<value template>]` pairs.
+func parseOutputs(s string) []Output {
+	var outputs []Output
+	for _, item := range splitList(s) {
+		key, value, _ := strings.Cut(item, "=")
+		outputs = append(outputs, Output{Key: strings.TrimSpace(key), Value: strings.TrimSpace(value)})
+	}
+	return outputs
+}
+
+// parseRoleValues parses comma-separated `<role>=<key>=<value>` items into the values of each role.
+func parseRoleValues(s string) (map[string]map[string]string, error) {
+	values := map[string]map[string]string{}
+	var errs []error
+	for _, item := range splitList(s) {
+		r, kv, _ := strings.Cut(item, "=")
+		key, value, ok := strings.Cut(kv, "=")
+		if r = strings.TrimSpace(r); r == "" || key == "" || !ok {
+			errs = append(errs, fmt.Errorf("invalid item %q, expected <role>=<key>=<value>", item))
+			continue
+		}
+		if values[r] == nil {
+			values[r] = map[string]string{}
+		}
+		values[r][key] = value
+	}
+	return values, errors.Join(errs...)
+}
+
+// setMetadata replaces the labels or annotations, as selected by field, of every role with the values.
+func (c *Config) setMetadata(values map[string]map[string]string, field func(*Metadata) *map[string]string) {
+	if c.Roles.Metadata == nil {
+		c.Roles.Metadata = map[string]Metadata{}
+	}
+	for r, md := range c.Roles.Metadata {
+		*field(&md) = nil
+		c.Roles.Metadata[r] = md
+	}
+	for r, v := range values {
+		md := c.Roles.Metadata[r]
+		*field(&md) = v
+		c.Roles.Metadata[r] = md
+	}
+}
+
+// parseTaints parses comma-separated `<role>=<key>[=<value>]:<effect>` items.
+func parseTaints(s string) (map[string][]Taint, error) {
+	taints := map[string][]Taint{}
+	var errs []error
+	for _, item := range splitList(s) {
+		r, taint, _ := strings.Cut(item, "=")
+		kv, effect, ok := strings.Cut(taint, ":")
+		if r == "" || kv == "" || !ok {
+			errs = append(errs, fmt.Errorf("invalid taint %q, expected <role>=<key>[=<value>]:<effect>", item))
+			continue
+		}
+		key, value, _ := strings.Cut(kv, "=")
+		taints[strings.TrimSpace(r)] = append(taints[strings.TrimSpace(r)], Taint{Key: key, Value: value, Effect: effect})
+	}
+	return taints, errors.Join(errs...)
+}
+
+func setBool(getenv func(string) string, name string, into *bool) error {
+	v := getenv(name)
+	if v == "" {
+		return nil
+	}
+	b, err := parseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*into = b
+	return nil
+}
+
+func setInt(getenv func(string) string, name string, into *int) error {
+	v := getenv(name)
+	if v == "" {
+		return nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		return fmt.Errorf("%s: invalid value %q, must be a positive integer", name, v)
+	}
+	*into = i
+	return nil
+}
+
+func setDuration(getenv func(string) string, name string, into *metav1.Duration) error {
+	v := getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%s: invalid value %q, must be a positive duration", name, v)
+	}
+	into.Duration = d
+	return nil
+}
+
+// splitList splits a comma-separated list, trimming whitespace and dropping empty items.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseRules parses newline-separated rules in the `<expression> -> <role>[,<role>...]` format.
+func parseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	var errs []error
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := fmt.Sprintf("rule-%d", len(rules)+len(errs)+1)
+		r, err := role.ParseRule(name, line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rules = append(rules, Rule{Name: r.Name, Expression: r.Expression, Roles: r.Roles})
+	}
+	return rules, errors.Join(errs...)
+}
+
+// parseBool parses one of the accepted true (true, 1, yes) or false (false, 0, no) strings.
+func parseBool(s string) (bool, error) {
+	switch strings.TrimSpace(strings.ToLower(s)) {
+	case "true", "1", "yes":
+		return true, nil
+	case "false", "0", "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid value %q, must be true, false, 1, 0, yes or no", s)
+}
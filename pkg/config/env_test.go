@@ -0,0 +1,184 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitList(t *testing.T) {
+	got := splitList(" nodeGroup, ,karpenter.sh/nodepool ,")
+	if len(got) != 2 || got[0] != "nodeGroup" || got[1] != "karpenter.sh/nodepool" {
+		t.Errorf("unexpected list: %v", got)
+	}
+	if got := splitList(""); len(got) != 0 {
+		t.Errorf("expected empty list, got %v", got)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := parseRules(`
+# gpu nodes
+node.status.allocatable['nvidia.com/gpu'] > 0 -> gpu
+'ingress' in node.metadata.labels -> ingress, edge
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("expected 2 rules, got %d", len(rules))
+	}
+	if rules[0].Name != "rule-1" || rules[1].Name != "rule-2" {
+		t.Errorf("unexpected rule names: %s, %s", rules[0].Name, rules[1].Name)
+	}
+	if len(rules[1].Roles) != 2 {
+		t.Errorf("expected 2 roles, got %v", rules[1].Roles)
+	}
+
+	if _, err := parseRules("true"); err == nil {
+		t.Error("expected error for rule without roles")
+	}
+}
+
+func TestParseOutputs(t *testing.T) {
+	got := parseOutputs("node-role.kubernetes.io/{{.Role}}=true, kubernetes.io/role={{.Role}}, example.com/{{.Role}}")
+	want := []Output{
+		{Key: "node-role.kubernetes.io/{{.Role}}", Value: "true"},
+		{Key: "kubernetes.io/role", Value: "{{.Role}}"},
+		{Key: "example.com/{{.Role}}"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for _, v := range []string{"true", " TRUE", "1", "yes"} {
+		if b, err := parseBool(v); err != nil || !b {
+			t.Errorf("parseBool(%q) = %v, %v, want true", v, b, err)
+		}
+	}
+	for _, v := range []string{"false", "0", "No "} {
+		if b, err := parseBool(v); err != nil || b {
+			t.Errorf("parseBool(%q) = %v, %v, want false", v, b, err)
+		}
+	}
+	for _, v := range []string{"", "on", "off", "enabled"} {
+		if _, err := parseBool(v); err == nil {
+			t.Errorf("parseBool(%q) expected error", v)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"ROLE_LABEL":               "nodeGroup, karpenter.sh/nodepool",
+		"ROLE_LABEL_MODE":          "all",
+		"ROLE_LABEL_REPLACE":       "true",
+		"ROLE_LABEL_TRUSTED":       "true",
+		"ROLE_NORMALIZE_LOWERCASE": "true",
+		"ROLE_PROTECTED_DENY":      "control-plane, master, infra-.*",
+		"ROLE_TAINTS":              "gpu=dedicated=gpu:NoSchedule, gpu=nvidia.com/gpu:NoExecute",
+		"STARTUP_TAINT":            "rolesetter.io/unlabeled:NoSchedule",
+		"ROLE_EXTRA_LABELS":        "gpu=workload-class=accelerated, ingress=workload-class=edge",
+		"STATUS_ANNOTATION":        "true",
+		"STATUS_HISTORY":           "10",
+		"WORKERS":                  "4",
+		"NAMESPACE":                "node-labeler",
+		"POD_NAME":                 "controller-abc",
+		"LEASE_DURATION":           "30s",
+	}
+	c := &Config{
+		APIVersion: APIVersion,
+		Sources:    Sources{Labels: []string{"from-file"}},
+		Server:     Server{Port: 9090},
+	}
+	if err := c.applyEnv(func(k string) string { return env[k] }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Sources.Labels) != 2 || c.Sources.Labels[0] != "nodeGroup" {
+		t.Errorf("expected env to override labels, got %v", c.Sources.Labels)
+	}
+	if c.Sources.Mode != "all" || !c.Mappings.Replace || c.Controller.Workers != 4 {
+		t.Errorf("unexpected overrides: %+v", c)
+	}
+	if !c.Sources.Trusted.Enabled {
+		t.Error("expected env to enable trusted sources")
+	}
+	if !c.Roles.Normalize.Lowercase {
+		t.Error("expected env to enable lowercase normalization")
+	}
+	if ts := c.Roles.Taints["gpu"]; len(ts) != 2 || ts[0] != (Taint{Key: "dedicated", Value: "gpu", Effect: "NoSchedule"}) || ts[1].Key != "nvidia.com/gpu" {
+		t.Errorf("expected env to set the role taints, got %+v", ts)
+	}
+	if md := c.Roles.Metadata; md["gpu"].Labels["workload-class"] != "accelerated" || md["ingress"].Labels["workload-class"] != "edge" {
+		t.Errorf("expected env to set the extra labels, got %+v", md)
+	}
+	if s := c.Apply.StartupTaint; s == nil || s.Key != "rolesetter.io/unlabeled" || s.Effect != "NoSchedule" {
+		t.Errorf("expected env to set the startup taint, got %+v", s)
+	}
+	if s := c.Apply.Status; !s.Enabled || s.History != 10 {
+		t.Errorf("expected env to set the status annotation, got %+v", s)
+	}
+	if d := c.Roles.Protected.Deny; len(d) != 3 || d[2] != "infra-.*" {
+		t.Errorf("expected env to set the protected deny list, got %v", d)
+	}
+	if c.Server.Port != 9090 {
+		t.Errorf("expected unset env to keep file value, got %d", c.Server.Port)
+	}
+	le := c.LeaderElection
+	if le.Namespace != "node-labeler" || le.Identity != "controller-abc" || le.LeaseDuration.Duration != 30*time.Second {
+		t.Errorf("unexpected leader election overrides: %+v", le)
+	}
+}
+
+func TestApplyEnv_PodNameIdentity(t *testing.T) {
+	tests := []struct {
+		name     string
+		podName  string
+		identity string
+		want     string
+	}{
+		{name: "pod name", podName: "controller-abc", want: "controller-abc"},
+		{name: "pod name wins over the file", podName: "controller-abc", identity: "from-file", want: "controller-abc"},
+		{name: "file identity without pod name", identity: "from-file", want: "from-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := map[string]string{"POD_NAME": tt.podName}
+			c := &Config{LeaderElection: LeaderElection{Identity: tt.identity}}
+			if err := c.applyEnv(func(k string) string { return env[k] }); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c.LeaderElection.Identity != tt.want {
+				t.Errorf("expected identity %q, got %q", tt.want, c.LeaderElection.Identity)
+			}
+		})
+	}
+}
+
+func TestApplyEnv_ReportsAllErrors(t *testing.T) {
+	env := map[string]string{
+		"WORKERS":            "zero",
+		"SERVER_PORT":        "-1",
+		"LEASE_RETRY_PERIOD": "2",
+		"ROLE_RULES":         "true",
+		"ROLE_TAINTS":        "gpu",
+		"ROLE_EXTRA_LABELS":  "gpu=workload-class",
+		"DRY_RUN":            "on",
+	}
+	err := (&Config{}).applyEnv(func(k string) string { return env[k] })
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, name := range []string{"WORKERS", "SERVER_PORT", "LEASE_RETRY_PERIOD", "ROLE_RULES", "ROLE_TAINTS", "ROLE_EXTRA_LABELS", "DRY_RUN"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected error to mention %s, got %v", name, err)
+		}
+	}
+}
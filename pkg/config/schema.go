@@ -0,0 +1,50 @@
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+)
+
+// Schema is the published JSON schema of the config file.
+//
+//go:embed config.schema.json
+var Schema []byte
+
+var schemaValidator = mustSchemaValidator(Schema)
+
+func mustSchemaValidator(data []byte) *validate.SchemaValidator {
+	var s spec.Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		panic("invalid config schema: " + err.Error())
+	}
+	return validate.NewSchemaValidator(&s, nil, "", strfmt.Default)
+}
+
+// validateSchema validates the decoded document against the schema, reporting all errors found.
+func validateSchema(doc interface{}) error {
+	res := schemaValidator.Validate(doc)
+	if res.IsValid() {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(res.Errors))
+	for _, err := range res.Errors {
+		// Errors read "<path> in body ..."; the document is the config file, not a request body
+		msgs = append(msgs, strings.Replace(err.Error(), " in body", "", 1))
+	}
+	sort.Strings(msgs)
+
+	errs := make([]error, 0, len(msgs))
+	for _, msg := range msgs {
+		errs = append(errs, errors.New(msg))
+	}
+	return fmt.Errorf("schema validation failed: %w", errors.Join(errs...))
+}
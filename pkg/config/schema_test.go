@@ -0,0 +1,38 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSchema_IsValidJSON(t *testing.T) {
+	var v map[string]interface{}
+	if err := json.Unmarshal(Schema, &v); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr bool
+	}{
+		{"minimal", `{"apiVersion": "rolesetter/v1"}`, false},
+		{"missing apiVersion", `{}`, true},
+		{"unknown field", `{"apiVersion": "rolesetter/v1", "roles": []}`, true},
+		{"rule without roles", `{"apiVersion": "rolesetter/v1", "mappings": {"rules": [{"expression": "true"}]}}`, true},
+		{"port out of range", `{"apiVersion": "rolesetter/v1", "server": {"port": 70000}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc interface{}
+			if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := validateSchema(doc); (err != nil) != tt.wantErr {
+				t.Errorf("validateSchema() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Watch polls the config file at path and calls onChange with the config loaded from it
+// whenever its content changes, or onError when the changed file fails to load.
+// Polling follows the symlinks kubelet swaps when a mounted ConfigMap is updated.
+// It blocks until the context is done.
+func Watch(ctx context.Context, path string, interval time.Duration, getenv func(string) string,
+	onChange func(*Config), onError func(error)) {
+	last, _ := digest(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		sum, err := digest(path)
+		if err != nil {
+			// Report an unreadable file once, not on every tick
+			if last != "" {
+				onError(err)
+			}
+			last = ""
+			continue
+		}
+		if sum == last {
+			continue
+		}
+		last = sum
+
+		cfg, err := Load(path, getenv)
+		if err != nil {
+			onError(err)
+			continue
+		}
+		onChange(cfg)
+	}
+}
+
+// digest returns the hash of the file content.
+func digest(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
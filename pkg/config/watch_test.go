@@ -0,0 +1,61 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("apiVersion: rolesetter/v1\nsources:\n  labels: [nodeGroup]\n")
+
+	changes := make(chan *Config, 10)
+	errs := make(chan error, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Watch(ctx, path, 10*time.Millisecond, func(string) string { return "" },
+			func(c *Config) { changes <- c },
+			func(err error) { errs <- err },
+		)
+	}()
+
+	// Let the watcher record the initial content before changing it
+	time.Sleep(50 * time.Millisecond)
+	write("apiVersion: rolesetter/v1\nsources:\n  labels: [pool]\n")
+	select {
+	case c := <-changes:
+		if c.Sources.Labels[0] != "pool" {
+			t.Errorf("unexpected labels: %v", c.Sources.Labels)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for change")
+	}
+
+	write("apiVersion: rolesetter/v1\nsources:\n  mode: some\n")
+	select {
+	case <-errs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for error")
+	}
+
+	// An unchanged file isn't reloaded again
+	time.Sleep(50 * time.Millisecond)
+	if len(changes) != 0 || len(errs) != 0 {
+		t.Errorf("unexpected reloads: %d changes, %d errors", len(changes), len(errs))
+	}
+
+	cancel()
+	<-done
+}
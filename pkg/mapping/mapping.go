@@ -0,0 +1,99 @@
+package mapping
+
+import (
+	"fmt"
+
+	"github.com/mchmarny/rolesetter/pkg/role"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Group is the API group of the NodeRoleMapping resource.
+	Group = "rolesetter.io"
+	// Version is the API version of the NodeRoleMapping resource.
+	Version = "v1alpha1"
+	// Kind is the kind of the NodeRoleMapping resource.
+	Kind = "NodeRoleMapping"
+	// Resource is the plural resource name of NodeRoleMapping.
+	Resource = "noderolemappings"
+)
+
+// GroupVersionResource identifies the cluster-scoped NodeRoleMapping resource.
+var GroupVersionResource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: Resource}
+
+// NodeRoleMapping declares roles for the nodes matching its selector.
+// Teams can own their mappings through RBAC on the resource.
+type NodeRoleMapping struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeRoleMappingSpec   `json:"spec"`
+	Status NodeRoleMappingStatus `json:"status,omitempty"`
+}
+
+// NodeRoleMappingSpec is the desired mapping.
+type NodeRoleMappingSpec struct {
+	// NodeSelector limits the nodes the mapping applies to; empty matches every node.
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// Source is where the roles come from.
+	Source Source `json:"source"`
+	// Roles are emitted when the source expression matches.
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Source is exactly one of a label, an annotation or a CEL expression.
+type Source struct {
+	// Label is the label key whose value becomes the role.
+	Label string `json:"label,omitempty"`
+	// Annotation is the annotation key whose value becomes the role.
+	Annotation string `json:"annotation,omitempty"`
+	// Expression is a CEL expression evaluated against the `node` variable.
+	Expression string `json:"expression,omitempty"`
+}
+
+// NodeRoleMappingStatus is the observed state of the mapping.
+type NodeRoleMappingStatus struct {
+	// ObservedGeneration is the generation of the spec the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// MatchedNodes is the number of nodes the mapping resolved roles for.
+	MatchedNodes int64 `json:"matchedNodes"`
+	// Conflicts describe roles the mapping resolved that are also resolved from another
+	// source, or owned by another field manager.
+	Conflicts []string `json:"conflicts,omitempty"`
+	// Error is set when the mapping is invalid and therefore ignored.
+	Error string `json:"error,omitempty"`
+}
+
+// FromUnstructured converts the object read by a dynamic client.
+func FromUnstructured(u *unstructured.Unstructured) (*NodeRoleMapping, error) {
+	var m NodeRoleMapping
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &m); err != nil {
+		return nil, fmt.Errorf("failed to convert %s %s: %w", Kind, u.GetName(), err)
+	}
+	return &m, nil
+}
+
+// RoleMapping converts and validates the mapping for the role handler.
+func (m *NodeRoleMapping) RoleMapping() (role.Mapping, error) {
+	rm := role.Mapping{
+		Name:       m.Name,
+		Label:      m.Spec.Source.Label,
+		Annotation: m.Spec.Source.Annotation,
+		Expression: m.Spec.Source.Expression,
+		Roles:      m.Spec.Roles,
+	}
+	if m.Spec.NodeSelector != nil {
+		sel, err := metav1.LabelSelectorAsSelector(m.Spec.NodeSelector)
+		if err != nil {
+			return role.Mapping{}, fmt.Errorf("invalid node selector: %w", err)
+		}
+		rm.Selector = sel
+	}
+	if err := role.ValidateMapping(rm); err != nil {
+		return role.Mapping{}, err
+	}
+	return rm, nil
+}
@@ -0,0 +1,117 @@
+package mapping
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func newUnstructured(name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       Kind,
+		"metadata":   map[string]interface{}{"name": name, "generation": int64(2)},
+		"spec":       spec,
+	}}
+}
+
+func TestFromUnstructured(t *testing.T) {
+	u := newUnstructured("gpu", map[string]interface{}{
+		"nodeSelector": map[string]interface{}{
+			"matchLabels": map[string]interface{}{"team": "ml"},
+		},
+		"source": map[string]interface{}{"expression": "'gpu' in node.metadata.labels"},
+		"roles":  []interface{}{"gpu"},
+	})
+
+	m, err := FromUnstructured(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Name != "gpu" || m.Generation != 2 {
+		t.Errorf("unexpected metadata: %+v", m.ObjectMeta)
+	}
+	if m.Spec.Source.Expression == "" || len(m.Spec.Roles) != 1 || m.Spec.NodeSelector.MatchLabels["team"] != "ml" {
+		t.Errorf("unexpected spec: %+v", m.Spec)
+	}
+
+	if _, err := FromUnstructured(newUnstructured("bad", map[string]interface{}{"roles": "gpu"})); err == nil {
+		t.Error("expected error for invalid spec")
+	}
+}
+
+func TestNodeRoleMapping_RoleMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    map[string]interface{}
+		wantErr string
+	}{
+		{
+			name: "label",
+			spec: map[string]interface{}{"source": map[string]interface{}{"label": "team"}},
+		},
+		{
+			name: "selector",
+			spec: map[string]interface{}{
+				"nodeSelector": map[string]interface{}{"matchLabels": map[string]interface{}{"team": "ml"}},
+				"source":       map[string]interface{}{"annotation": "example.com/role"},
+			},
+		},
+		{
+			name: "invalid selector",
+			spec: map[string]interface{}{
+				"nodeSelector": map[string]interface{}{"matchExpressions": []interface{}{
+					map[string]interface{}{"key": "team", "operator": "Bogus"},
+				}},
+				"source": map[string]interface{}{"label": "team"},
+			},
+			wantErr: "invalid node selector",
+		},
+		{
+			name:    "no source",
+			spec:    map[string]interface{}{"source": map[string]interface{}{}},
+			wantErr: "exactly one of",
+		},
+		{
+			name: "invalid expression",
+			spec: map[string]interface{}{
+				"source": map[string]interface{}{"expression": "node.nope("},
+				"roles":  []interface{}{"gpu"},
+			},
+			wantErr: "mapping invalid-expression",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := strings.ReplaceAll(tt.name, " ", "-")
+			m, err := FromUnstructured(newUnstructured(name, tt.spec))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			rm, err := m.RoleMapping()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if rm.Name != name {
+				t.Errorf("expected name %s, got %s", name, rm.Name)
+			}
+			if m.Spec.NodeSelector != nil {
+				n := &corev1.Node{}
+				n.Labels = map[string]string{"team": "ml"}
+				if rm.Selector == nil || !rm.Selector.Matches(labels.Set(n.Labels)) {
+					t.Error("expected selector to match node")
+				}
+			}
+		})
+	}
+}
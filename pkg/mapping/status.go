@@ -0,0 +1,63 @@
+package mapping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// maxConflicts bounds the conflicts written to the status so it stays small on large clusters.
+const maxConflicts = 10
+
+// NewStatus builds the status for the mapping from its matched nodes, conflicts and validation error.
+func NewStatus(m *NodeRoleMapping, matched int, conflicts []string, err error) NodeRoleMappingStatus {
+	s := NodeRoleMappingStatus{
+		ObservedGeneration: m.Generation,
+		MatchedNodes:       int64(matched),
+	}
+	if len(conflicts) > maxConflicts {
+		extra := len(conflicts) - maxConflicts
+		conflicts = append(conflicts[:maxConflicts:maxConflicts], fmt.Sprintf("and %d more", extra))
+	}
+	s.Conflicts = conflicts
+	if err != nil {
+		s.Error = err.Error()
+	}
+	return s
+}
+
+// UpdateStatus patches the status subresource of the mapping unless it already has the status.
+func UpdateStatus(ctx context.Context, client dynamic.Interface, m *NodeRoleMapping, status NodeRoleMappingStatus) error {
+	if equality.Semantic.DeepEqual(m.Status, status) {
+		return nil
+	}
+
+	// Null clears fields omitted from the new status in the merge patch
+	patch := map[string]any{"status": map[string]any{
+		"observedGeneration": status.ObservedGeneration,
+		"matchedNodes":       status.MatchedNodes,
+		"conflicts":          nil,
+		"error":              nil,
+	}}
+	s := patch["status"].(map[string]any)
+	if len(status.Conflicts) > 0 {
+		s["conflicts"] = status.Conflicts
+	}
+	if status.Error != "" {
+		s["error"] = status.Error
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status patch: %w", err)
+	}
+	if _, err := client.Resource(GroupVersionResource).Patch(ctx, m.Name, types.MergePatchType, data, metav1.PatchOptions{}, "status"); err != nil {
+		return fmt.Errorf("failed to update status of %s %s: %w", Kind, m.Name, err)
+	}
+	return nil
+}
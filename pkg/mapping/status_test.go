@@ -0,0 +1,83 @@
+package mapping
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNewStatus(t *testing.T) {
+	m := &NodeRoleMapping{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Generation: 3}}
+
+	s := NewStatus(m, 2, nil, nil)
+	if s.ObservedGeneration != 3 || s.MatchedNodes != 2 || s.Conflicts != nil || s.Error != "" {
+		t.Errorf("unexpected status: %+v", s)
+	}
+
+	var conflicts []string
+	for n := 0; n < maxConflicts+5; n++ {
+		conflicts = append(conflicts, fmt.Sprintf("conflict %d", n))
+	}
+	s = NewStatus(m, 0, conflicts, errors.New("invalid"))
+	if len(s.Conflicts) != maxConflicts+1 || s.Conflicts[maxConflicts] != "and 5 more" {
+		t.Errorf("expected truncated conflicts, got %v", s.Conflicts)
+	}
+	if len(conflicts) != maxConflicts+5 || conflicts[maxConflicts] != "conflict 10" {
+		t.Error("expected input conflicts to be left unchanged")
+	}
+	if s.Error != "invalid" {
+		t.Errorf("expected error, got %q", s.Error)
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	u := newUnstructured("gpu", map[string]interface{}{"source": map[string]interface{}{"label": "team"}})
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{GroupVersionResource: Kind + "List"}, u)
+
+	m, err := FromUnstructured(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Unchanged status is not written
+	if err := UpdateStatus(context.Background(), client, m, m.Status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := len(client.Actions()); n != 0 {
+		t.Fatalf("expected no actions, got %d", n)
+	}
+
+	status := NewStatus(m, 4, []string{"conflict"}, nil)
+	if err := UpdateStatus(context.Background(), client, m, status); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	actions := client.Actions()
+	if len(actions) != 1 {
+		t.Fatalf("expected one action, got %d", len(actions))
+	}
+	patch, ok := actions[0].(k8stesting.PatchAction)
+	if !ok || patch.GetSubresource() != "status" {
+		t.Fatalf("expected status patch, got %v", actions[0])
+	}
+
+	got, err := client.Resource(GroupVersionResource).Get(context.Background(), "gpu", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	updated, err := FromUnstructured(got)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if updated.Status.MatchedNodes != 4 || len(updated.Status.Conflicts) != 1 {
+		t.Errorf("unexpected status: %+v", updated.Status)
+	}
+}
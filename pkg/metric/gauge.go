@@ -0,0 +1,49 @@
+package metric
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SettableGauge interface {
+	Set(value float64, val ...string)
+	Delete(val ...string)
+}
+
+type Gauge struct {
+	Name string
+	Help string
+
+	vec *prometheus.GaugeVec
+}
+
+func (g *Gauge) Set(value float64, val ...string) {
+	g.vec.WithLabelValues(val...).Set(value)
+}
+
+func (g *Gauge) Delete(val ...string) {
+	g.vec.DeleteLabelValues(val...)
+}
+
+func NewGauge(name, help string, labels ...string) SettableGauge {
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: name,
+		Help: help,
+	}, labels)
+
+	if err := prometheus.Register(gauge); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			gauge = are.ExistingCollector.(*prometheus.GaugeVec)
+		} else {
+			panic("failed to register gauge " + name + ": " + err.Error())
+		}
+	}
+
+	return &Gauge{
+		Name: name,
+		Help: help,
+		vec:  gauge,
+	}
+}
@@ -0,0 +1,37 @@
+package metric
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestGauge_SetAndDelete(t *testing.T) {
+	g := NewGauge("test_gauge_set", "test gauge", "node")
+	g.Set(3, "n1")
+	g.Set(1, "n2")
+
+	impl, ok := g.(*Gauge)
+	if !ok {
+		t.Fatal("NewGauge did not return *Gauge type")
+	}
+	if got := testutil.ToFloat64(impl.vec.WithLabelValues("n1")); got != 3 {
+		t.Errorf("expected 3, got %v", got)
+	}
+
+	g.Delete("n1")
+	if got := testutil.CollectAndCount(impl.vec); got != 1 {
+		t.Errorf("expected 1 series after delete, got %d", got)
+	}
+}
+
+func TestGauge_SafeReRegistration(t *testing.T) {
+	name := "test_gauge_safe_rereg"
+	g1 := NewGauge(name, "first", "label")
+	g2 := NewGauge(name, "first", "label")
+	if g1 == nil || g2 == nil {
+		t.Fatal("NewGauge returned nil on re-registration")
+	}
+	g1.Set(1, "a")
+	g2.Set(2, "b")
+}
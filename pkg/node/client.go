@@ -3,6 +3,7 @@ package node
 import (
 	"fmt"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -12,17 +13,39 @@ const (
 	clientBurst = 20
 )
 
-// newClient creates a Kubernetes clientset for interacting with the cluster.
-func newClient() (kubernetes.Interface, error) {
+// newConfig returns the in-cluster client config.
+func newConfig() (*rest.Config, error) {
 	cfg, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cluster config: %w", err)
 	}
 	cfg.QPS = clientQPS
 	cfg.Burst = clientBurst
+	return cfg, nil
+}
+
+// newClient creates a Kubernetes clientset for interacting with the cluster.
+func newClient() (kubernetes.Interface, error) {
+	cfg, err := newConfig()
+	if err != nil {
+		return nil, err
+	}
 	cs, err := kubernetes.NewForConfig(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create clientset: %w", err)
 	}
 	return cs, nil
 }
+
+// newDynamicClient creates a dynamic client for the custom resources the controller reads.
+func newDynamicClient() (dynamic.Interface, error) {
+	cfg, err := newConfig()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dynamic.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	return dc, nil
+}
@@ -0,0 +1,244 @@
+package node
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	queueName     = "nodes"
+	workerBackoff = time.Second
+
+	failureReasonTransient = "transient"
+	failureReasonPermanent = "permanent"
+)
+
+var failedGauge = metric.NewGauge("node_role_failed_nodes", "Number of nodes whose last reconcile failed", "reason")
+
+// failures tracks the nodes whose last reconcile failed, by reason.
+type failures struct {
+	mu    sync.Mutex
+	nodes map[string]string
+}
+
+func newFailures() *failures {
+	f := &failures{nodes: map[string]string{}}
+	f.report()
+	return f
+}
+
+// set records the failure reason for the node.
+func (f *failures) set(name, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nodes[name] = reason
+	f.report()
+}
+
+// clear removes the node from the failed set.
+func (f *failures) clear(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.nodes[name]; !ok {
+		return
+	}
+	delete(f.nodes, name)
+	f.report()
+}
+
+// count returns the number of failed nodes with the reason.
+func (f *failures) count(reason string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := 0
+	for _, r := range f.nodes {
+		if r == reason {
+			c++
+		}
+	}
+	return c
+}
+
+// report updates the gauge; callers must hold the lock.
+func (f *failures) report() {
+	counts := map[string]int{failureReasonTransient: 0, failureReasonPermanent: 0}
+	for _, r := range f.nodes {
+		counts[r]++
+	}
+	for reason, c := range counts {
+		failedGauge.Set(float64(c), reason)
+	}
+}
+
+// controller reconciles nodes from a rate-limited workqueue keyed by node name.
+// The workqueue guarantees a node never has more than one reconcile in flight.
+// The handler can be swapped at runtime when the configuration is reloaded.
+type controller struct {
+	logger  *zap.Logger
+	handler atomic.Pointer[role.CacheResourceHandler]
+	lister  corelisters.NodeLister
+	queue   workqueue.TypedRateLimitingInterface[string]
+	failed  *failures
+}
+
+// newController creates a controller for the handler reading nodes from the lister.
+func newController(logger *zap.Logger, handler *role.CacheResourceHandler, lister corelisters.NodeLister) *controller {
+	c := &controller{
+		logger: logger,
+		lister: lister,
+		queue: workqueue.NewTypedRateLimitingQueueWithConfig(
+			workqueue.DefaultTypedControllerRateLimiter[string](),
+			workqueue.TypedRateLimitingQueueConfig[string]{Name: queueName},
+		),
+		failed: newFailures(),
+	}
+	c.handler.Store(handler)
+	return c
+}
+
+// setHandler replaces the handler used for subsequent reconciles.
+func (c *controller) setHandler(handler *role.CacheResourceHandler) {
+	c.handler.Store(handler)
+}
+
+// enqueueAll adds every cached node to the queue.
+func (c *controller) enqueueAll() {
+	nodes, err := c.lister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error("failed to list nodes from cache", zap.Error(err))
+		return
+	}
+	for _, n := range nodes {
+		c.queue.Add(n.Name)
+	}
+	c.logger.Info("enqueued all nodes", zap.Int("nodes", len(nodes)))
+}
+
+// eventHandler returns the informer callbacks that enqueue nodes.
+func (c *controller) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if n, ok := obj.(*corev1.Node); ok {
+				c.queue.Add(n.Name)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, ok := oldObj.(*corev1.Node)
+			if !ok {
+				return
+			}
+			newNode, ok := newObj.(*corev1.Node)
+			if !ok {
+				return
+			}
+			// Periodic resyncs (same resource version) always pass through so that
+			// drift is eventually corrected; real updates only when relevant
+			if oldNode.ResourceVersion != newNode.ResourceVersion && !c.handler.Load().Relevant(oldNode, newNode) {
+				return
+			}
+			c.queue.Add(newNode.Name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if n, ok := obj.(*corev1.Node); ok {
+				c.forget(n.Name)
+			}
+		},
+	}
+}
+
+// run starts the workers and blocks until the context is done and every worker has returned,
+// so no reconcile of this controller is still in flight once it returns.
+func (c *controller) run(ctx context.Context, workers int) {
+	c.logger.Info("starting workers", zap.Int("workers", workers))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(ctx, c.runWorker, workerBackoff)
+		}()
+	}
+
+	<-ctx.Done()
+	c.logger.Info("stopping workers")
+	c.queue.ShutDown()
+	wg.Wait()
+}
+
+// runWorker processes nodes until the queue is shut down or the context is done.
+func (c *controller) runWorker(ctx context.Context) {
+	for ctx.Err() == nil && c.processNextItem(ctx) {
+	}
+}
+
+// processNextItem reconciles the next node in the queue, returning false when the queue is shut down.
+func (c *controller) processNextItem(ctx context.Context) bool {
+	name, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(name)
+
+	n, err := c.lister.Get(name)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			c.forget(name)
+			return true
+		}
+		c.logger.Error("failed to get node from cache",
+			zap.String("node", name),
+			zap.Error(err),
+		)
+		c.queue.AddRateLimited(name)
+		return true
+	}
+
+	if err := c.handler.Load().EnsureRole(ctx, n); err != nil {
+		// Permanent errors (Forbidden, Invalid, conflicts) won't resolve by retrying, so they wait
+		// for the next relevant update or resync; transient ones back off per node
+		if role.IsPermanent(err) {
+			c.failed.set(name, failureReasonPermanent)
+			c.queue.Forget(name)
+			c.logger.Error("reconcile failed permanently, waiting for node update or resync",
+				zap.String("node", name),
+				zap.Error(err),
+			)
+			return true
+		}
+		c.failed.set(name, failureReasonTransient)
+		c.queue.AddRateLimited(name)
+		c.logger.Warn("reconcile failed, requeued",
+			zap.String("node", name),
+			zap.Int("requeues", c.queue.NumRequeues(name)),
+			zap.Error(err),
+		)
+		return true
+	}
+
+	c.failed.clear(name)
+	c.queue.Forget(name)
+	return true
+}
+
+// forget drops all state kept for a node that no longer exists.
+func (c *controller) forget(name string) {
+	c.queue.Forget(name)
+	c.failed.clear(name)
+	c.handler.Load().Forget(name)
+}
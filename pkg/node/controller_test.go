@@ -0,0 +1,255 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+type testPatcher struct {
+	mu    sync.Mutex
+	names []string
+	err   error
+}
+
+func (p *testPatcher) patch(_ context.Context, name string, _ types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.names = append(p.names, name)
+	return nil, p.err
+}
+
+func (p *testPatcher) calls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.names...)
+}
+
+func getTestNode(name, rv string, labels map[string]string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			ResourceVersion: rv,
+			Labels:          labels,
+		},
+	}
+}
+
+func newTestController(t *testing.T, p *testPatcher, nodes ...*corev1.Node) *controller {
+	t.Helper()
+	h, err := role.NewCacheResourceHandler(p.patch, logger.GetTestLogger(), role.WithSources("nodeGroup"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for _, n := range nodes {
+		if err := indexer.Add(n); err != nil {
+			t.Fatalf("failed to add node: %v", err)
+		}
+	}
+	return newController(logger.GetTestLogger(), h, corelisters.NewNodeLister(indexer))
+}
+
+func TestController_EventHandlerFiltersUpdates(t *testing.T) {
+	c := newTestController(t, &testPatcher{})
+	defer c.queue.ShutDown()
+	eh := c.eventHandler()
+
+	base := getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"})
+
+	tests := []struct {
+		name    string
+		newNode *corev1.Node
+		want    int
+	}{
+		{name: "irrelevant change", newNode: getTestNode("n1", "2", map[string]string{"nodeGroup": "worker"}), want: 0},
+		{name: "resync", newNode: getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"}), want: 1},
+		{name: "label change", newNode: getTestNode("n1", "3", map[string]string{"nodeGroup": "gpu"}), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eh.OnUpdate(base, tt.newNode)
+			if got := c.queue.Len(); got != tt.want {
+				t.Errorf("expected queue length %d, got %d", tt.want, got)
+			}
+			for c.queue.Len() > 0 {
+				name, _ := c.queue.Get()
+				c.queue.Done(name)
+			}
+		})
+	}
+
+	eh.OnAdd(base, false)
+	eh.OnAdd(base, false)
+	if got := c.queue.Len(); got != 1 {
+		t.Errorf("expected duplicate adds to collapse into one item, got %d", got)
+	}
+}
+
+func TestController_ProcessNextItem(t *testing.T) {
+	p := &testPatcher{}
+	c := newTestController(t, p, getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"}))
+	defer c.queue.ShutDown()
+
+	c.queue.Add("n1")
+	c.queue.Add("missing")
+	for range 2 {
+		if !c.processNextItem(context.Background()) {
+			t.Fatal("expected queue to keep processing")
+		}
+	}
+
+	if calls := p.calls(); len(calls) != 1 || calls[0] != "n1" {
+		t.Errorf("expected only n1 to be patched, got %v", calls)
+	}
+	if c.queue.Len() != 0 {
+		t.Errorf("expected empty queue, got %d", c.queue.Len())
+	}
+
+	c.queue.ShutDown()
+	if c.processNextItem(context.Background()) {
+		t.Error("expected processing to stop after shutdown")
+	}
+}
+
+func TestController_Run(t *testing.T) {
+	p := &testPatcher{}
+	c := newTestController(t, p,
+		getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"}),
+		getTestNode("n2", "1", map[string]string{"nodeGroup": "gpu"}),
+	)
+	c.queue.Add("n1")
+	c.queue.Add("n2")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx, 2)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for len(p.calls()) < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for reconciles, got %v", p.calls())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("controller did not stop")
+	}
+}
+
+func TestController_RunWaitsForWorkers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h, err := role.NewCacheResourceHandler(func(context.Context, string, types.PatchType, []byte, metav1.PatchOptions, ...string) (*corev1.Node, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}, logger.GetTestLogger(), role.WithSources("nodeGroup"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"})); err != nil {
+		t.Fatalf("failed to add node: %v", err)
+	}
+	c := newController(logger.GetTestLogger(), h, corelisters.NewNodeLister(indexer))
+	c.queue.Add("n1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.run(ctx, 1)
+		close(done)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the reconcile")
+	}
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("expected run to wait for the in-flight reconcile")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("controller did not stop")
+	}
+}
+
+func TestController_ProcessNextItemFailures(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantReason  string
+		wantRequeue bool
+	}{
+		{
+			name:        "transient error is requeued",
+			err:         errors.New("connection refused"),
+			wantReason:  failureReasonTransient,
+			wantRequeue: true,
+		},
+		{
+			name:       "permanent error is not requeued",
+			err:        apierrors.NewForbidden(schema.GroupResource{Resource: "nodes"}, "n1", errors.New("forbidden")),
+			wantReason: failureReasonPermanent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &testPatcher{err: tt.err}
+			c := newTestController(t, p, getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"}))
+			defer c.queue.ShutDown()
+
+			c.queue.Add("n1")
+			c.processNextItem(context.Background())
+
+			if got := c.failed.count(tt.wantReason); got != 1 {
+				t.Errorf("expected 1 %s failure, got %d", tt.wantReason, got)
+			}
+			if got := c.queue.NumRequeues("n1"); (got > 0) != tt.wantRequeue {
+				t.Errorf("expected requeue %v, got %d requeues", tt.wantRequeue, got)
+			}
+
+			// A later successful reconcile clears the failed state
+			p.mu.Lock()
+			p.err = nil
+			p.mu.Unlock()
+			c.queue.Add("n1")
+			c.processNextItem(context.Background())
+			if got := c.failed.count(tt.wantReason); got != 0 {
+				t.Errorf("expected failure to be cleared, got %d", got)
+			}
+			if got := c.queue.NumRequeues("n1"); got != 0 {
+				t.Errorf("expected requeues to be reset, got %d", got)
+			}
+		})
+	}
+}
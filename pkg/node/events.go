@@ -0,0 +1,45 @@
+package node
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// eventComponent is the source component of the events recorded on Nodes.
+const eventComponent = "rolesetter"
+
+// eventCorrelation aggregates and rate limits the events of a flapping node: after 5 events with
+// the same reason within 10 minutes, they are combined into a single event whose count is updated,
+// and each node gets a burst of 25 events refilled at one every 5 minutes.
+var eventCorrelation = record.CorrelatorOptions{
+	MaxEvents:            5,
+	MaxIntervalInSeconds: 600,
+	BurstSize:            25,
+	QPS:                  1. / 300,
+}
+
+// WithRecorder sets the recorder of the events reported on Nodes.
+// By default, events are sent to the API server.
+func WithRecorder(recorder record.EventRecorder) Option {
+	return func(i *Informer) {
+		i.recorder = recorder
+	}
+}
+
+// newRecorder creates the broadcaster sending aggregated events to the API server and its recorder.
+func newRecorder() (record.EventBroadcaster, record.EventRecorder) {
+	b := record.NewBroadcaster(record.WithCorrelatorOptions(eventCorrelation))
+	return b, b.NewRecorder(scheme.Scheme, corev1.EventSource{Component: eventComponent})
+}
+
+// startEvents starts sending the recorded events to the API server.
+// The returned function stops it.
+func (i *Informer) startEvents() func() {
+	if i.broadcaster == nil {
+		return func() {}
+	}
+	i.broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: i.clientset.CoreV1().Events("")})
+	return i.broadcaster.Shutdown
+}
@@ -0,0 +1,226 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/role"
+	"github.com/mchmarny/rolesetter/pkg/server"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// watchErrorWindow is how far back watch errors are counted.
+	watchErrorWindow = 2 * time.Minute
+	// watchErrorThreshold is the number of watch errors within the window that fails readiness.
+	watchErrorThreshold = 5
+	// activityTimeout is how long the leader may go without a successful API call before
+	// it is reported as not ready. Node events, resyncs and patches count as calls; an empty
+	// node cache gets none of them, so the probe keeps a healthy leader well within it.
+	activityTimeout = 3 * resyncInterval
+	// probeInterval is how often the leader lists a single node when no other API call has
+	// succeeded in that time.
+	probeInterval = resyncInterval
+	// campaignTimeoutMin is the shortest time leader election may go without an attempt
+	// to acquire or renew the lease before the replica is reported as not live.
+	campaignTimeoutMin = time.Minute
+)
+
+// health tracks the informer state that backs the liveness and readiness checks.
+type health struct {
+	leading      atomic.Bool
+	synced       atomic.Bool
+	exited       atomic.Bool
+	lastActivity atomic.Int64
+
+	// lastCampaign and campaignTimeout are only set with leader election
+	lastCampaign    atomic.Int64
+	campaignTimeout atomic.Int64
+
+	mu          sync.Mutex
+	watchErrors []time.Time
+
+	now func() time.Time
+}
+
+func newHealth() *health {
+	return &health{now: time.Now}
+}
+
+// register adds the informer checks to the server health registry.
+func (h *health) register(reg *server.Health) {
+	reg.AddLivenessCheck("informer", h.checkInformer)
+	reg.AddLivenessCheck("leader-election", h.checkCampaign)
+	reg.AddReadinessCheck("cache-sync", h.checkSynced)
+	reg.AddReadinessCheck("watch", h.checkWatch)
+	reg.AddReadinessCheck("api", h.checkActivity)
+}
+
+// setLeading records whether this replica is running the informer, reporting whether it changed.
+// Replicas on standby are healthy; only the leader's informer state is checked, and a
+// replica that gave up leadership is no longer expected to run the informer.
+func (h *health) setLeading(leading bool) bool {
+	if !h.leading.CompareAndSwap(!leading, leading) {
+		return false
+	}
+	if !leading {
+		h.synced.Store(false)
+		h.exited.Store(false)
+	}
+	return true
+}
+
+// setSynced records whether the informer cache has synced.
+func (h *health) setSynced(synced bool) {
+	h.synced.Store(synced)
+	if synced {
+		h.touch()
+	}
+}
+
+// setExited records that the informer stopped while it was supposed to be running.
+func (h *health) setExited() {
+	h.exited.Store(true)
+}
+
+// startCampaign enables the leader election liveness check, failing once no attempt to
+// acquire or renew the lease was made within the timeout.
+func (h *health) startCampaign(timeout time.Duration) {
+	h.campaignTimeout.Store(int64(timeout))
+	h.campaignProgress()
+}
+
+// campaignProgress records an attempt to acquire or renew the lease, successful or not,
+// so an unreachable API server does not fail liveness but a wedged campaign does.
+func (h *health) campaignProgress() {
+	h.lastCampaign.Store(h.now().UnixNano())
+}
+
+// touch records a successful API call.
+func (h *health) touch() {
+	h.lastActivity.Store(h.now().UnixNano())
+}
+
+// watchError records a failed list or watch and drops errors older than the window.
+func (h *health) watchError() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	h.watchErrors = append(h.prune(now), now)
+}
+
+// recentWatchErrors returns the number of watch errors within the window.
+func (h *health) recentWatchErrors() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watchErrors = h.prune(h.now())
+	return len(h.watchErrors)
+}
+
+// prune returns the watch errors within the window; callers must hold the lock.
+func (h *health) prune(now time.Time) []time.Time {
+	cutoff := now.Add(-watchErrorWindow)
+	i := 0
+	for i < len(h.watchErrors) && h.watchErrors[i].Before(cutoff) {
+		i++
+	}
+	return h.watchErrors[i:]
+}
+
+func (h *health) checkInformer() error {
+	if h.exited.Load() {
+		return fmt.Errorf("informer exited")
+	}
+	return nil
+}
+
+func (h *health) checkCampaign() error {
+	timeout := time.Duration(h.campaignTimeout.Load())
+	if timeout == 0 {
+		return nil
+	}
+	last := time.Unix(0, h.lastCampaign.Load())
+	if since := h.now().Sub(last); since > timeout {
+		return fmt.Errorf("no leader election attempt in %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+func (h *health) checkSynced() error {
+	if h.leading.Load() && !h.synced.Load() {
+		return fmt.Errorf("node cache not synced")
+	}
+	return nil
+}
+
+func (h *health) checkWatch() error {
+	if n := h.recentWatchErrors(); n >= watchErrorThreshold {
+		return fmt.Errorf("%d watch errors in the last %s", n, watchErrorWindow)
+	}
+	return nil
+}
+
+func (h *health) checkActivity() error {
+	if !h.leading.Load() || !h.synced.Load() {
+		return nil
+	}
+	last := time.Unix(0, h.lastActivity.Load())
+	if since := h.now().Sub(last); since > activityTimeout {
+		return fmt.Errorf("no successful API call in %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+// probe lists a single node every probeInterval until the context is done, so a leader whose
+// node cache is empty, e.g. a node selector that matches nothing yet, still records activity.
+func (h *health) probe(ctx context.Context, cs kubernetes.Interface) {
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.probeOnce(ctx, cs)
+		}
+	}
+}
+
+// probeOnce lists a single node and records it as a successful API call, unless another call
+// succeeded within the probe interval.
+func (h *health) probeOnce(ctx context.Context, cs kubernetes.Interface) {
+	if h.now().Sub(time.Unix(0, h.lastActivity.Load())) < probeInterval {
+		return
+	}
+	if _, err := cs.CoreV1().Nodes().List(ctx, metav1.ListOptions{Limit: 1}); err == nil {
+		h.touch()
+	}
+}
+
+// eventHandler returns informer callbacks that record every delivered node as a successful API call.
+func (h *health) eventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { h.touch() },
+		UpdateFunc: func(interface{}, interface{}) { h.touch() },
+		DeleteFunc: func(interface{}) { h.touch() },
+	}
+}
+
+// patcher wraps the patcher to record successful calls.
+func (h *health) patcher(patch role.NodePatcher) role.NodePatcher {
+	return func(ctx context.Context, name string, pt types.PatchType, data []byte,
+		opts metav1.PatchOptions, subresources ...string) (*corev1.Node, error) {
+		n, err := patch(ctx, name, pt, data, opts, subresources...)
+		if err == nil {
+			h.touch()
+		}
+		return n, err
+	}
+}
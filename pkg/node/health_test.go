@@ -0,0 +1,191 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func newTestHealth(now *time.Time) *health {
+	h := newHealth()
+	h.now = func() time.Time { return *now }
+	return h
+}
+
+func TestHealth_Synced(t *testing.T) {
+	now := time.Now()
+	h := newTestHealth(&now)
+
+	if err := h.checkSynced(); err != nil {
+		t.Errorf("standby replica should be ready, got %v", err)
+	}
+	h.setLeading(true)
+	if err := h.checkSynced(); err == nil {
+		t.Error("expected error before cache sync")
+	}
+	h.setSynced(true)
+	if err := h.checkSynced(); err != nil {
+		t.Errorf("unexpected error after cache sync: %v", err)
+	}
+	h.setLeading(false)
+	if h.synced.Load() {
+		t.Error("expected synced to reset when leadership is lost")
+	}
+}
+
+func TestHealth_Watch(t *testing.T) {
+	now := time.Now()
+	h := newTestHealth(&now)
+
+	for range watchErrorThreshold - 1 {
+		h.watchError()
+	}
+	if err := h.checkWatch(); err != nil {
+		t.Errorf("unexpected error below threshold: %v", err)
+	}
+	h.watchError()
+	if err := h.checkWatch(); err == nil {
+		t.Error("expected error at threshold")
+	}
+	now = now.Add(watchErrorWindow + time.Second)
+	if err := h.checkWatch(); err != nil {
+		t.Errorf("expected errors outside the window to be dropped, got %v", err)
+	}
+}
+
+func TestHealth_Activity(t *testing.T) {
+	now := time.Now()
+	h := newTestHealth(&now)
+	h.setLeading(true)
+	h.setSynced(true)
+
+	if err := h.checkActivity(); err != nil {
+		t.Errorf("unexpected error right after sync: %v", err)
+	}
+	now = now.Add(activityTimeout + time.Second)
+	if err := h.checkActivity(); err == nil {
+		t.Error("expected error after activity timeout")
+	}
+
+	patch := h.patcher(func(context.Context, string, types.PatchType, []byte, metav1.PatchOptions, ...string) (*corev1.Node, error) {
+		return &corev1.Node{}, nil
+	})
+	if _, err := patch(context.Background(), "n1", types.ApplyPatchType, nil, metav1.PatchOptions{}); err != nil {
+		t.Fatalf("unexpected patch error: %v", err)
+	}
+	if err := h.checkActivity(); err != nil {
+		t.Errorf("expected successful patch to count as activity, got %v", err)
+	}
+
+	now = now.Add(activityTimeout + time.Second)
+	failing := h.patcher(func(context.Context, string, types.PatchType, []byte, metav1.PatchOptions, ...string) (*corev1.Node, error) {
+		return nil, errors.New("boom")
+	})
+	_, _ = failing(context.Background(), "n1", types.ApplyPatchType, nil, metav1.PatchOptions{})
+	if err := h.checkActivity(); err == nil {
+		t.Error("expected failed patch not to count as activity")
+	}
+}
+
+func TestHealth_Campaign(t *testing.T) {
+	now := time.Now()
+	h := newTestHealth(&now)
+
+	// Without leader election there is no campaign to check
+	now = now.Add(time.Hour)
+	if err := h.checkCampaign(); err != nil {
+		t.Errorf("unexpected error without leader election: %v", err)
+	}
+
+	h.startCampaign(time.Minute)
+	now = now.Add(30 * time.Second)
+	if err := h.checkCampaign(); err != nil {
+		t.Errorf("unexpected error within the timeout: %v", err)
+	}
+	now = now.Add(time.Minute)
+	if err := h.checkCampaign(); err == nil {
+		t.Error("expected error after the campaign timeout")
+	}
+	h.campaignProgress()
+	if err := h.checkCampaign(); err != nil {
+		t.Errorf("expected an attempt to count as progress, got %v", err)
+	}
+}
+
+func TestHealth_Probe(t *testing.T) {
+	now := time.Now()
+	h := newTestHealth(&now)
+	h.setLeading(true)
+	h.setSynced(true)
+
+	// An empty node cache delivers no events, so only the probe records activity
+	clientset := fake.NewClientset()
+	lists := 0
+	clientset.PrependReactor("list", "nodes", func(clienttesting.Action) (bool, runtime.Object, error) {
+		lists++
+		return false, nil, nil
+	})
+
+	h.probeOnce(context.Background(), clientset)
+	if lists != 0 {
+		t.Errorf("expected no list right after sync, got %d", lists)
+	}
+
+	now = now.Add(activityTimeout + time.Second)
+	h.probeOnce(context.Background(), clientset)
+	if lists != 1 {
+		t.Errorf("expected one list, got %d", lists)
+	}
+	if err := h.checkActivity(); err != nil {
+		t.Errorf("expected successful probe to count as activity, got %v", err)
+	}
+
+	now = now.Add(activityTimeout + time.Second)
+	clientset.PrependReactor("list", "nodes", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("boom")
+	})
+	h.probeOnce(context.Background(), clientset)
+	if err := h.checkActivity(); err == nil {
+		t.Error("expected failed probe not to count as activity")
+	}
+}
+
+func TestInformer_RunInformerExited(t *testing.T) {
+	newInformer := func() *Informer {
+		return &Informer{
+			logger:    logger.GetTestLogger(),
+			manager:   fieldManagerDefault,
+			workers:   workersDefault,
+			clientset: fake.NewClientset(),
+			health:    newHealth(),
+		}
+	}
+
+	// Shutting down is not an exit
+	i := newInformer()
+	i.labels = []string{"test-label"}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = i.runInformer(ctx)
+	if err := i.health.checkInformer(); err != nil {
+		t.Errorf("expected no exit on shutdown, got %v", err)
+	}
+
+	// Returning while the context is still live is
+	i = newInformer()
+	if err := i.runInformer(context.Background()); err == nil {
+		t.Fatal("expected error without labels or rules")
+	}
+	if err := i.health.checkInformer(); err == nil {
+		t.Error("expected error after informer exited")
+	}
+}
@@ -14,31 +14,91 @@ import (
 	"github.com/mchmarny/rolesetter/pkg/server"
 	"go.uber.org/zap"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
-	resyncInterval     = 5 * time.Minute
-	servicePortDefault = 8080
-	leaseName          = "node-role-controller"
-	leaseDuration      = 15 * time.Second
-	renewDeadline      = 10 * time.Second
-	retryPeriod        = 2 * time.Second
+	resyncInterval       = 5 * time.Minute
+	servicePortDefault   = 8080
+	workersDefault       = 2
+	fieldManagerDefault  = "rolesetter"
+	leaseNameDefault     = "node-role-controller"
+	leaseDurationDefault = 15 * time.Second
+	renewDeadlineDefault = 10 * time.Second
+	retryPeriodDefault   = 2 * time.Second
+)
+
+var (
+	leaderGauge        = metric.NewGauge("rolesetter_leader", "Whether this replica is the leader (1) or not (0)")
+	transitionsCounter = metric.NewCounter("rolesetter_leader_transitions_total", "Total number of times this replica gained or lost leadership")
 )
 
 // Informer is responsible for managing the node role setter controller.
 type Informer struct {
-	logger    *zap.Logger
-	label     string
-	replace   bool
-	port      int
-	namespace string
-	clientset kubernetes.Interface
-	server    server.Server
+	logger      *zap.Logger
+	labels      []string
+	labelMode   role.SourceMode
+	separator   string
+	rules       []role.Rule
+	transforms  []role.Transform
+	defaultRole string
+	normalize   role.Normalization
+	outputs     []role.Output
+	protection  role.Protection
+	trust       role.Trust
+	taints      map[string][]role.Taint
+	startup     role.StartupTaint
+	metadata    map[string]role.Metadata
+	status      role.Status
+	replace     bool
+	gc          bool
+	manager     string
+	force       bool
+	dryRun      bool
+	plans       *role.PlanStore
+	overrides   *role.OverrideStore
+	workers     int
+	port        int
+	namespace   string
+	selector    string
+	lease       leaseConfig
+	clientset   kubernetes.Interface
+	dynamic     dynamic.Interface
+	server      server.Server
+	health      *health
+
+	broadcaster record.EventBroadcaster
+	recorder    record.EventRecorder
+
+	watchMappings bool
+	mappingStore  *role.MappingStore
+
+	configFile string
+	getenv     func(string) string
+
+	// mu guards the role settings above, the config generation and the running controller during reloads
+	mu         sync.Mutex
+	generation int64
+	ctrl       *controller
+	mappings   []role.Mapping
+	// mappingsSynced is set once the NodeRoleMapping resources are loaded
+	mappingsSynced bool
+}
+
+// leaseConfig configures the leader election Lease.
+type leaseConfig struct {
+	name          string
+	identity      string
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
 }
 
 // Option is a functional option for configuring Informer.
@@ -50,6 +110,41 @@ func WithReplace(replace bool) Option {
 	}
 }
 
+// WithGC sets whether role labels applied by the controller are removed once their source is gone.
+func WithGC(gc bool) Option {
+	return func(i *Informer) {
+		i.gc = gc
+	}
+}
+
+// WithFieldManager sets the server-side apply field manager used to track controller-owned labels.
+func WithFieldManager(manager string) Option {
+	return func(i *Informer) {
+		i.manager = manager
+	}
+}
+
+// WithForce sets whether ownership of conflicting labels is forcibly taken from other field managers.
+func WithForce(force bool) Option {
+	return func(i *Informer) {
+		i.force = force
+	}
+}
+
+// WithDryRun sets whether role changes are only planned and reported instead of applied.
+func WithDryRun(dryRun bool) Option {
+	return func(i *Informer) {
+		i.dryRun = dryRun
+	}
+}
+
+// WithWorkers sets the number of nodes reconciled concurrently.
+func WithWorkers(workers int) Option {
+	return func(i *Informer) {
+		i.workers = workers
+	}
+}
+
 // WithLogger sets the logger for the Informer.
 func WithLogger(logger *zap.Logger) Option {
 	return func(i *Informer) {
@@ -57,10 +152,101 @@ func WithLogger(logger *zap.Logger) Option {
 	}
 }
 
-// WithLabel sets the label for the Informer.
-func WithLabel(label string) Option {
+// WithLabels sets the priority-ordered source labels for the Informer.
+func WithLabels(labels ...string) Option {
+	return func(i *Informer) {
+		i.labels = labels
+	}
+}
+
+// WithLabelMode sets how multiple source labels are resolved into roles.
+func WithLabelMode(mode role.SourceMode) Option {
+	return func(i *Informer) {
+		i.labelMode = mode
+	}
+}
+
+// WithSeparator sets the separator splitting a source label value into several roles.
+func WithSeparator(sep string) Option {
+	return func(i *Informer) {
+		i.separator = sep
+	}
+}
+
+// WithRules sets the CEL role rules for the Informer.
+func WithRules(rules ...role.Rule) Option {
+	return func(i *Informer) {
+		i.rules = rules
+	}
+}
+
+// WithTransforms sets the chain that turns source label values into roles.
+func WithTransforms(transforms ...role.Transform) Option {
+	return func(i *Informer) {
+		i.transforms = transforms
+	}
+}
+
+// WithDefaultRole sets the role used when the transforms turn a source label value empty.
+func WithDefaultRole(r string) Option {
+	return func(i *Informer) {
+		i.defaultRole = r
+	}
+}
+
+// WithNormalization sets how resolved role values are normalized before they are validated.
+func WithNormalization(n role.Normalization) Option {
+	return func(i *Informer) {
+		i.normalize = n
+	}
+}
+
+// WithProtection sets the roles that are never added or removed.
+func WithProtection(p role.Protection) Option {
+	return func(i *Informer) {
+		i.protection = p
+	}
+}
+
+// WithTrust sets which source labels are trusted to drive roles.
+func WithTrust(t role.Trust) Option {
+	return func(i *Informer) {
+		i.trust = t
+	}
+}
+
+// WithTaints sets the taints applied to the nodes with each role.
+func WithTaints(taints map[string][]role.Taint) Option {
+	return func(i *Informer) {
+		i.taints = taints
+	}
+}
+
+// WithStartupTaint sets the taint removed from nodes once their roles are applied.
+func WithStartupTaint(s role.StartupTaint) Option {
+	return func(i *Informer) {
+		i.startup = s
+	}
+}
+
+// WithMetadata sets the extra labels and annotations written to the nodes with each role.
+func WithMetadata(metadata map[string]role.Metadata) Option {
+	return func(i *Informer) {
+		i.metadata = metadata
+	}
+}
+
+// WithStatus sets whether the outcome of each reconcile is recorded in the status annotation of the node.
+func WithStatus(s role.Status) Option {
 	return func(i *Informer) {
-		i.label = label
+		i.status = s
+	}
+}
+
+// WithOutputs sets the labels written for every resolved role.
+func WithOutputs(outputs ...role.Output) Option {
+	return func(i *Informer) {
+		i.outputs = outputs
 	}
 }
 
@@ -78,6 +264,13 @@ func WithClientset(cs kubernetes.Interface) Option {
 	}
 }
 
+// WithDynamicClient sets the dynamic client used to watch NodeRoleMapping resources.
+func WithDynamicClient(dc dynamic.Interface) Option {
+	return func(i *Informer) {
+		i.dynamic = dc
+	}
+}
+
 // WithNamespace sets the namespace for leader election.
 // When set, leader election is enabled using a Lease in this namespace.
 func WithNamespace(ns string) Option {
@@ -86,12 +279,76 @@ func WithNamespace(ns string) Option {
 	}
 }
 
+// WithNodeSelector limits the managed nodes to those matching the label selector.
+func WithNodeSelector(selector string) Option {
+	return func(i *Informer) {
+		i.selector = selector
+	}
+}
+
+// WithLeaseName sets the name of the leader election Lease.
+// Controllers with different configurations in the same namespace need different lease names.
+func WithLeaseName(name string) Option {
+	return func(i *Informer) {
+		i.lease.name = name
+	}
+}
+
+// WithIdentity sets the leader election identity of this replica, which must be unique per replica.
+// The service passes POD_NAME from the downward API; an empty identity falls back to the hostname.
+func WithIdentity(id string) Option {
+	return func(i *Informer) {
+		i.lease.identity = id
+	}
+}
+
+// WithLeaseDuration sets how long non-leaders wait before trying to take over an unrenewed lease.
+func WithLeaseDuration(d time.Duration) Option {
+	return func(i *Informer) {
+		i.lease.leaseDuration = d
+	}
+}
+
+// WithRenewDeadline sets how long the leader keeps retrying to renew the lease before giving it up.
+func WithRenewDeadline(d time.Duration) Option {
+	return func(i *Informer) {
+		i.lease.renewDeadline = d
+	}
+}
+
+// WithRetryPeriod sets how long to wait between leader election attempts.
+func WithRetryPeriod(d time.Duration) Option {
+	return func(i *Informer) {
+		i.lease.retryPeriod = d
+	}
+}
+
+// defaultInformer returns an Informer with the default settings.
+func defaultInformer() *Informer {
+	return &Informer{
+		port:       servicePortDefault,
+		labelMode:  role.SourceModeFirst,
+		manager:    fieldManagerDefault,
+		workers:    workersDefault,
+		generation: 1,
+		lease: leaseConfig{
+			name:          leaseNameDefault,
+			leaseDuration: leaseDurationDefault,
+			renewDeadline: renewDeadlineDefault,
+			retryPeriod:   retryPeriodDefault,
+		},
+	}
+}
+
 // NewInformer creates a new Informer instance using functional options.
 func NewInformer(opts ...Option) (*Informer, error) {
-	i := &Informer{
-		logger: logger.GetLogger(),
-		port:   servicePortDefault,
-	}
+	i := defaultInformer()
+	i.logger = logger.GetLogger()
+	i.plans = role.NewPlanStore()
+	i.overrides = role.NewOverrideStore()
+	i.mappingStore = role.NewMappingStore()
+	i.health = newHealth()
+	i.getenv = os.Getenv
 
 	for _, opt := range opts {
 		opt(i)
@@ -105,11 +362,24 @@ func NewInformer(opts ...Option) (*Informer, error) {
 		}
 		i.clientset = cs
 	}
+	if i.watchMappings && i.dynamic == nil {
+		dc, err := newDynamicClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		}
+		i.dynamic = dc
+	}
+	if i.recorder == nil {
+		i.broadcaster, i.recorder = newRecorder()
+	}
 
 	if i.server == nil {
+		checks := server.NewHealth()
+		i.health.register(checks)
 		i.server = server.NewServer(
 			server.WithLogger(i.logger),
 			server.WithPort(i.port),
+			server.WithHealth(checks),
 		)
 	}
 
@@ -124,21 +394,88 @@ func (i *Informer) validate() error {
 	if i.logger == nil {
 		return fmt.Errorf("logger must not be nil")
 	}
-	if i.label == "" {
-		return fmt.Errorf("roleLabel must be specified")
+	if len(i.labels) == 0 && len(i.rules) == 0 && !i.watchMappings {
+		return fmt.Errorf("roleLabel, rules or mapping resources must be specified")
+	}
+	if _, err := role.ParseSourceMode(string(i.labelMode)); err != nil {
+		return err
+	}
+	if err := role.ValidateSeparator(i.separator); err != nil {
+		return err
+	}
+	if err := role.ValidateRules(i.rules...); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	if err := role.ValidateTransforms(i.transforms...); err != nil {
+		return fmt.Errorf("invalid transforms: %w", err)
+	}
+	if err := role.ValidateOutputs(i.outputs...); err != nil {
+		return fmt.Errorf("invalid outputs: %w", err)
+	}
+	if err := i.protection.Validate(); err != nil {
+		return fmt.Errorf("invalid protected roles: %w", err)
+	}
+	if err := i.trust.Validate(); err != nil {
+		return fmt.Errorf("invalid trust: %w", err)
+	}
+	if err := role.ValidateTaints(i.taints); err != nil {
+		return fmt.Errorf("invalid taints: %w", err)
+	}
+	if err := role.ValidateMetadata(i.metadata); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if err := i.startup.Validate(); err != nil {
+		return fmt.Errorf("invalid startup taint: %w", err)
+	}
+	if err := i.status.Validate(); err != nil {
+		return fmt.Errorf("invalid status: %w", err)
+	}
+	if i.manager == "" {
+		return fmt.Errorf("fieldManager must not be empty")
+	}
+	if i.workers <= 0 {
+		return fmt.Errorf("workers must be a positive integer")
 	}
 	if i.port <= 0 {
 		return fmt.Errorf("serverPort must be a positive integer")
 	}
+	if _, err := labels.Parse(i.selector); err != nil {
+		return fmt.Errorf("invalid node selector: %w", err)
+	}
+	if i.namespace != "" {
+		if err := i.lease.validate(); err != nil {
+			return fmt.Errorf("invalid leader election config: %w", err)
+		}
+	}
 	if i.clientset == nil {
 		return fmt.Errorf("kubernetes clientset must not be nil")
 	}
+	if i.watchMappings && i.dynamic == nil {
+		return fmt.Errorf("dynamic client must not be nil when watching mappings")
+	}
 	if i.server == nil {
 		return fmt.Errorf("server must not be nil")
 	}
 	return nil
 }
 
+// validate checks the lease timings using the same constraints as the leader elector.
+func (l leaseConfig) validate() error {
+	if l.name == "" {
+		return fmt.Errorf("lease name must not be empty")
+	}
+	if l.retryPeriod <= 0 {
+		return fmt.Errorf("retryPeriod must be positive")
+	}
+	if l.renewDeadline <= time.Duration(leaderelection.JitterFactor*float64(l.retryPeriod)) {
+		return fmt.Errorf("renewDeadline (%s) must be greater than retryPeriod*%.1f", l.renewDeadline, leaderelection.JitterFactor)
+	}
+	if l.leaseDuration <= l.renewDeadline {
+		return fmt.Errorf("leaseDuration (%s) must be greater than renewDeadline (%s)", l.leaseDuration, l.renewDeadline)
+	}
+	return nil
+}
+
 // Inform runs the node role setter controller with context, logger, and config.
 func (i *Informer) Inform(ctx context.Context) error {
 	if err := i.validate(); err != nil {
@@ -146,27 +483,54 @@ func (i *Informer) Inform(ctx context.Context) error {
 	}
 
 	i.logger.Info("starting node role setter",
-		zap.String("label", i.label),
+		zap.Strings("labels", i.labels),
+		zap.String("labelMode", string(i.labelMode)),
+		zap.Int("rules", len(i.rules)),
+		zap.Bool("replace", i.replace),
+		zap.Bool("gc", i.gc),
+		zap.String("fieldManager", i.manager),
+		zap.Bool("dryRun", i.dryRun),
+		zap.Bool("watchMappings", i.watchMappings),
+		zap.Int("workers", i.workers),
 		zap.Int("port", i.port),
+		zap.String("nodeSelector", i.selector),
 		zap.String("namespace", i.namespace),
+		zap.String("leaseName", i.lease.name),
 	)
 
+	if i.health == nil {
+		i.health = newHealth()
+	}
+	configGenerationGauge.Set(float64(i.configGeneration()))
+
+	if i.configFile != "" {
+		go i.watchConfig(ctx)
+	}
+
+	stopEvents := i.startEvents()
+	defer stopEvents()
+
 	// Start metrics server (always runs, regardless of leadership)
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		i.server.Serve(ctx, map[string]http.Handler{
-			"/metrics": metric.GetHandler(),
+			"/metrics":   metric.GetHandler(),
+			"/plan":      i.plans,
+			"/overrides": i.overrides,
 		})
 	}()
 
 	// Run informer with or without leader election
+	leaderGauge.Set(0)
 	if i.namespace != "" {
 		if err := i.runWithLeaderElection(ctx); err != nil {
 			return err
 		}
 	} else {
+		i.health.setLeading(true)
+		leaderGauge.Set(1)
 		if err := i.runInformer(ctx); err != nil {
 			return err
 		}
@@ -176,42 +540,95 @@ func (i *Informer) Inform(ctx context.Context) error {
 	return nil
 }
 
+// runWithLeaderElection campaigns for the lease and runs the informer while leading.
+// After leadership is lost, or the informer fails, it re-enters the election until the context is done.
 func (i *Informer) runWithLeaderElection(ctx context.Context) error {
-	id, err := os.Hostname()
-	if err != nil {
-		return fmt.Errorf("failed to get hostname: %w", err)
+	id := i.lease.identity
+	if id == "" {
+		h, err := os.Hostname()
+		if err != nil {
+			return fmt.Errorf("failed to get hostname: %w", err)
+		}
+		id = h
 	}
 
+	// Every acquire or renew attempt happens within a lease duration, and joining a
+	// finished term is short, so a longer gap means the campaign is wedged
+	i.health.startCampaign(max(campaignTimeoutMin, 4*i.lease.leaseDuration))
+
+	for {
+		if err := i.campaign(ctx, id); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		i.logger.Info("re-entering leader election", zap.Duration("after", i.lease.retryPeriod))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(i.lease.retryPeriod):
+		}
+	}
+}
+
+// campaign runs one round of leader election, returning once leadership is lost or the context is done.
+func (i *Informer) campaign(ctx context.Context, id string) error {
 	i.logger.Info("starting leader election",
 		zap.String("identity", id),
 		zap.String("namespace", i.namespace),
+		zap.String("lease", i.lease.name),
+		zap.Duration("leaseDuration", i.lease.leaseDuration),
+		zap.Duration("renewDeadline", i.lease.renewDeadline),
+		zap.Duration("retryPeriod", i.lease.retryPeriod),
 	)
 
-	lock := &resourcelock.LeaseLock{
-		LeaseMeta: metav1.ObjectMeta{
-			Name:      leaseName,
-			Namespace: i.namespace,
-		},
-		Client: i.clientset.CoordinationV1(),
-		LockConfig: resourcelock.ResourceLockConfig{
-			Identity: id,
+	lock := &progressLock{
+		Interface: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Name:      i.lease.name,
+				Namespace: i.namespace,
+			},
+			Client: i.clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: id,
+			},
 		},
+		progress: i.health.campaignProgress,
 	}
 
+	// Cancelling the round releases the lease so another replica can take over
+	roundCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// le.Run returns without waiting for OnStartedLeading, so the term is joined before
+	// the next round can start another informer alongside it
+	var t term
+	defer t.wait()
+
 	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
 		Lock:            lock,
 		ReleaseOnCancel: true,
-		LeaseDuration:   leaseDuration,
-		RenewDeadline:   renewDeadline,
-		RetryPeriod:     retryPeriod,
+		LeaseDuration:   i.lease.leaseDuration,
+		RenewDeadline:   i.lease.renewDeadline,
+		RetryPeriod:     i.lease.retryPeriod,
 		Callbacks: leaderelection.LeaderCallbacks{
 			OnStartedLeading: func(ctx context.Context) {
+				if !t.start() {
+					return
+				}
+				defer t.done()
+				i.setLeader(true)
 				if runErr := i.runInformer(ctx); runErr != nil {
-					i.logger.Error("informer failed", zap.Error(runErr))
+					i.logger.Error("informer failed, releasing leadership", zap.Error(runErr))
+					cancel()
 				}
 			},
 			OnStoppedLeading: func() {
-				i.logger.Info("lost leadership")
+				if i.setLeader(false) {
+					i.logger.Info("lost leadership")
+				}
 			},
 			OnNewLeader: func(identity string) {
 				if identity == id {
@@ -225,40 +642,201 @@ func (i *Informer) runWithLeaderElection(ctx context.Context) error {
 		return fmt.Errorf("failed to create leader elector: %w", err)
 	}
 
-	le.Run(ctx)
+	le.Run(roundCtx)
 	return nil
 }
 
+// progressLock records every read or update of the lease, i.e. every attempt to acquire
+// or renew it, as leader election progress for the liveness check.
+type progressLock struct {
+	resourcelock.Interface
+	progress func()
+}
+
+func (l *progressLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	l.progress()
+	return l.Interface.Get(ctx)
+}
+
+func (l *progressLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	l.progress()
+	return l.Interface.Update(ctx, ler)
+}
+
+// term joins the informer run of a leader election round.
+type term struct {
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// start reports whether the run may begin, i.e. the round has not been joined yet.
+func (t *term) start() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.wg.Add(1)
+	return true
+}
+
+// done marks the run as returned.
+func (t *term) done() {
+	t.wg.Done()
+}
+
+// wait keeps any later run from starting and waits for the started one to return.
+func (t *term) wait() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+// setLeader records leadership changes in health and metrics, reporting whether it changed.
+func (i *Informer) setLeader(leading bool) bool {
+	if !i.health.setLeading(leading) {
+		return false
+	}
+	transitionsCounter.Increment()
+	if leading {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+	return true
+}
+
+// runInformer runs the node informer and workers until the context is done, and returns
+// once everything it started has stopped. If it returns before the context is done,
+// the informer is reported as exited on /livez.
 func (i *Informer) runInformer(ctx context.Context) error {
-	handler, err := role.NewCacheResourceHandler(
-		i.clientset.CoreV1().Nodes().Patch,
-		i.logger,
-		i.label,
-		i.replace,
-	)
+	defer func() {
+		if ctx.Err() == nil {
+			i.health.setExited()
+		}
+	}()
+
+	runCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// Mappings load in the background, e.g. the CRD may not be installed; until they do,
+	// nodes are reconciled without removing role labels so GC never sees a partial rule set
+	if i.watchMappings {
+		i.mu.Lock()
+		i.mappingsSynced = false
+		i.mu.Unlock()
+		mappings, err := i.startMappings(runCtx)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mappings.run(runCtx)
+		}()
+	}
+
+	// The handler and controller are created under the lock so a concurrent reload
+	// either lands before and is picked up here, or after and swaps the handler
+	i.mu.Lock()
+	handler, err := i.newHandler()
 	if err != nil {
+		i.mu.Unlock()
 		return fmt.Errorf("failed to create role handler: %w", err)
 	}
 
-	factory := informers.NewSharedInformerFactory(i.clientset, resyncInterval)
-	eventHandler := cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			handler.EnsureRole(ctx, obj)
-		},
-		UpdateFunc: func(_, newObj interface{}) {
-			handler.EnsureRole(ctx, newObj)
-		},
-	}
+	factory := informers.NewSharedInformerFactoryWithOptions(i.clientset, resyncInterval,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) {
+			o.LabelSelector = i.selector
+		}),
+	)
+	nodes := factory.Core().V1().Nodes()
+	ctrl := newController(i.logger, handler, nodes.Lister())
+	i.ctrl = ctrl
+	i.mu.Unlock()
+
+	// Only clear the state this run set, in case a later one has already replaced it
+	defer func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		if i.ctrl == ctrl {
+			i.ctrl = nil
+			i.health.setSynced(false)
+		}
+	}()
 
-	inf := factory.Core().V1().Nodes().Informer()
-	if _, err := inf.AddEventHandler(eventHandler); err != nil {
+	inf := nodes.Informer()
+	if _, err := inf.AddEventHandler(ctrl.eventHandler()); err != nil {
 		return fmt.Errorf("failed to add event handler: %w", err)
 	}
+	if _, err := inf.AddEventHandler(i.health.eventHandler()); err != nil {
+		return fmt.Errorf("failed to add health event handler: %w", err)
+	}
+	if err := inf.SetWatchErrorHandlerWithContext(func(ctx context.Context, r *cache.Reflector, err error) {
+		i.health.watchError()
+		cache.DefaultWatchErrorHandler(ctx, r, err)
+	}); err != nil {
+		return fmt.Errorf("failed to set watch error handler: %w", err)
+	}
 
-	factory.Start(ctx.Done())
-	if !cache.WaitForCacheSync(ctx.Done(), inf.HasSynced) {
+	factory.Start(runCtx.Done())
+	// Runs once runCtx is done: the only returns below are after it
+	defer factory.Shutdown()
+	if !cache.WaitForCacheSync(runCtx.Done(), inf.HasSynced) {
 		return fmt.Errorf("cache sync failed")
 	}
-	<-ctx.Done()
+	i.health.setSynced(true)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		i.health.probe(runCtx, i.clientset)
+	}()
+
+	ctrl.run(runCtx, i.workers)
 	return nil
 }
+
+// newHandler creates the role handler from the Informer's role settings.
+func (i *Informer) newHandler() (*role.CacheResourceHandler, error) {
+	opts := []role.Option{
+		role.WithSources(i.labels...),
+		role.WithSourceMode(i.labelMode),
+		role.WithSeparator(i.separator),
+		role.WithRules(i.rules...),
+		role.WithTransforms(i.transforms...),
+		role.WithDefaultRole(i.defaultRole),
+		role.WithNormalization(i.normalize),
+		role.WithOutputs(i.outputs...),
+		role.WithProtection(i.protection),
+		role.WithTrust(i.trust),
+		role.WithTaints(i.taints),
+		role.WithStartupTaint(i.startup),
+		role.WithMetadata(i.metadata),
+		role.WithStatus(i.status),
+		role.WithGeneration(i.generation),
+		role.WithReplace(i.replace),
+		role.WithGC(i.gc),
+		role.WithFieldManager(i.manager),
+		role.WithForce(i.force),
+		role.WithDryRun(i.dryRun),
+		role.WithPlanStore(i.plans),
+		role.WithOverrideStore(i.overrides),
+	}
+	if i.recorder != nil {
+		opts = append(opts, role.WithRecorder(i.recorder))
+	}
+	if i.watchMappings {
+		opts = append(opts,
+			role.WithMappings(i.mappings...),
+			role.WithMappingStore(i.mappingStore),
+			role.WithMappingsPending(!i.mappingsSynced),
+		)
+	}
+	return role.NewCacheResourceHandler(i.health.patcher(i.clientset.CoreV1().Nodes().Patch), i.logger, opts...)
+}
@@ -3,8 +3,10 @@ package node
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/mchmarny/rolesetter/pkg/logger"
+	"github.com/mchmarny/rolesetter/pkg/role"
 	"github.com/mchmarny/rolesetter/pkg/server"
 	"k8s.io/client-go/kubernetes/fake"
 )
@@ -19,7 +21,9 @@ func TestInformer_Validate(t *testing.T) {
 
 	inf := &Informer{
 		logger:    logger,
-		label:     "test-label",
+		labels:    []string{"test-label"},
+		manager:   fieldManagerDefault,
+		workers:   workersDefault,
 		port:      8080,
 		clientset: clientset,
 		server:    srv,
@@ -39,7 +43,9 @@ func TestInformer_Inform_ContextCancel(t *testing.T) {
 
 	inf := &Informer{
 		logger:    logger,
-		label:     "test-label",
+		labels:    []string{"test-label"},
+		manager:   fieldManagerDefault,
+		workers:   workersDefault,
 		port:      8080,
 		clientset: clientset,
 		server:    srv,
@@ -60,6 +66,39 @@ func TestWithReplace_SetsReplace(t *testing.T) {
 	}
 }
 
+func TestWithGC_SetsGC(t *testing.T) {
+	i := &Informer{}
+	WithGC(true)(i)
+	if !i.gc {
+		t.Error("WithGC did not set gc to true")
+	}
+}
+
+func TestWithFieldManager_SetsManager(t *testing.T) {
+	i := &Informer{}
+	WithFieldManager("custom")(i)
+	WithForce(true)(i)
+	if i.manager != "custom" || !i.force {
+		t.Error("WithFieldManager/WithForce did not set field manager options")
+	}
+}
+
+func TestWithDryRun_SetsDryRun(t *testing.T) {
+	i := &Informer{}
+	WithDryRun(true)(i)
+	if !i.dryRun {
+		t.Error("WithDryRun did not set dryRun to true")
+	}
+}
+
+func TestWithWorkers_SetsWorkers(t *testing.T) {
+	i := &Informer{}
+	WithWorkers(4)(i)
+	if i.workers != 4 {
+		t.Error("WithWorkers did not set workers")
+	}
+}
+
 func TestWithLogger_SetsLogger(t *testing.T) {
 	l := logger.GetTestLogger()
 	i := &Informer{}
@@ -69,12 +108,27 @@ func TestWithLogger_SetsLogger(t *testing.T) {
 	}
 }
 
-func TestWithLabel_SetsLabel(t *testing.T) {
-	label := "foo"
+func TestWithLabels_SetsLabels(t *testing.T) {
+	i := &Informer{}
+	WithLabels("foo", "bar")(i)
+	if len(i.labels) != 2 || i.labels[0] != "foo" || i.labels[1] != "bar" {
+		t.Error("WithLabels did not set labels in order")
+	}
+}
+
+func TestWithLabelMode_SetsLabelMode(t *testing.T) {
+	i := &Informer{}
+	WithLabelMode(role.SourceModeAll)(i)
+	if i.labelMode != role.SourceModeAll {
+		t.Error("WithLabelMode did not set label mode")
+	}
+}
+
+func TestWithRules_SetsRules(t *testing.T) {
 	i := &Informer{}
-	WithLabel(label)(i)
-	if i.label != label {
-		t.Error("WithLabel did not set label")
+	WithRules(role.Rule{Name: "gpu", Expression: "true", Roles: []string{"gpu"}})(i)
+	if len(i.rules) != 1 || i.rules[0].Name != "gpu" {
+		t.Error("WithRules did not set rules")
 	}
 }
 
@@ -105,7 +159,15 @@ func TestValidate_Errors(t *testing.T) {
 	if err := i.validate(); err == nil {
 		t.Error("expected error for missing label")
 	}
-	i.label = "foo"
+	i.labels = []string{"foo"}
+	if err := i.validate(); err == nil {
+		t.Error("expected error for missing field manager")
+	}
+	i.manager = "foo"
+	if err := i.validate(); err == nil {
+		t.Error("expected error for missing workers")
+	}
+	i.workers = 1
 	if err := i.validate(); err == nil {
 		t.Error("expected error for missing port")
 	}
@@ -121,7 +183,7 @@ func TestNewInformer_Validation(t *testing.T) {
 
 	inf, err := NewInformer(
 		WithLogger(logger),
-		WithLabel("test-label"),
+		WithLabels("test-label"),
 		WithPort(8080),
 		WithClientset(clientset),
 	)
@@ -141,4 +203,216 @@ func TestNewInformer_Validation(t *testing.T) {
 	if err == nil {
 		t.Error("expected error for missing label")
 	}
+
+	// Rules alone are sufficient
+	_, err = NewInformer(
+		WithLogger(logger),
+		WithRules(role.Rule{Name: "gpu", Expression: "node.status.allocatable['nvidia.com/gpu'] > 0", Roles: []string{"gpu"}}),
+		WithPort(8080),
+		WithClientset(clientset),
+	)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Invalid rules fail at startup
+	_, err = NewInformer(
+		WithLogger(logger),
+		WithRules(role.Rule{Name: "bad", Expression: "node.", Roles: []string{"gpu"}}),
+		WithPort(8080),
+		WithClientset(clientset),
+	)
+	if err == nil {
+		t.Error("expected error for invalid rule")
+	}
+}
+
+func TestInformer_SetLeader(t *testing.T) {
+	i := &Informer{health: newHealth()}
+	if i.setLeader(false) {
+		t.Error("expected no transition when not leading")
+	}
+	if !i.setLeader(true) {
+		t.Error("expected transition on gaining leadership")
+	}
+	if i.setLeader(true) {
+		t.Error("expected no transition when already leading")
+	}
+	i.health.setExited()
+	if !i.setLeader(false) {
+		t.Error("expected transition on losing leadership")
+	}
+	if err := i.health.checkInformer(); err != nil {
+		t.Errorf("expected exit to be cleared after losing leadership, got %v", err)
+	}
+}
+
+func TestInformer_RunWithLeaderElection(t *testing.T) {
+	i := &Informer{
+		logger:    logger.GetTestLogger(),
+		labels:    []string{"test-label"},
+		manager:   fieldManagerDefault,
+		workers:   workersDefault,
+		namespace: "default",
+		lease: leaseConfig{
+			name:          leaseNameDefault,
+			identity:      "test-pod",
+			leaseDuration: leaseDurationDefault,
+			renewDeadline: renewDeadlineDefault,
+			retryPeriod:   retryPeriodDefault,
+		},
+		clientset: fake.NewClientset(),
+		health:    newHealth(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- i.runWithLeaderElection(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !i.health.leading.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for leadership")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if i.health.lastCampaign.Load() == 0 {
+		t.Error("expected lease attempts to be recorded as campaign progress")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leader election did not stop after cancel")
+	}
+	if i.health.leading.Load() {
+		t.Error("expected leadership to be released")
+	}
+}
+
+func TestTerm(t *testing.T) {
+	var tm term
+	if !tm.start() {
+		t.Fatal("expected the run to start")
+	}
+
+	joined := make(chan struct{})
+	go func() {
+		tm.wait()
+		close(joined)
+	}()
+	select {
+	case <-joined:
+		t.Fatal("expected wait to block until the run is done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	tm.done()
+	select {
+	case <-joined:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait did not return after the run was done")
+	}
+	if tm.start() {
+		t.Error("expected no run to start after the term was joined")
+	}
+}
+
+func TestInformer_RunInformerKeepsNewerController(t *testing.T) {
+	i := &Informer{
+		logger:    logger.GetTestLogger(),
+		labels:    []string{"test-label"},
+		manager:   fieldManagerDefault,
+		workers:   workersDefault,
+		clientset: fake.NewClientset(),
+		health:    newHealth(),
+	}
+	i.health.setLeading(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- i.runInformer(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !i.health.synced.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for sync")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A newer run took over before this one returned
+	newer := &controller{}
+	i.mu.Lock()
+	i.ctrl = newer
+	i.mu.Unlock()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if i.ctrl != newer {
+		t.Error("expected the newer controller to be kept")
+	}
+	if !i.health.synced.Load() {
+		t.Error("expected the newer run's sync state to be kept")
+	}
+}
+
+func TestLeaseConfig_Validate(t *testing.T) {
+	valid := leaseConfig{
+		name:          leaseNameDefault,
+		leaseDuration: leaseDurationDefault,
+		renewDeadline: renewDeadlineDefault,
+		retryPeriod:   retryPeriodDefault,
+	}
+	tests := []struct {
+		name    string
+		mutate  func(*leaseConfig)
+		wantErr bool
+	}{
+		{"defaults", func(*leaseConfig) {}, false},
+		{"empty name", func(l *leaseConfig) { l.name = "" }, true},
+		{"zero retry period", func(l *leaseConfig) { l.retryPeriod = 0 }, true},
+		{"renew deadline too short", func(l *leaseConfig) { l.renewDeadline = 2 * time.Second }, true},
+		{"lease shorter than renew deadline", func(l *leaseConfig) { l.leaseDuration = 5 * time.Second }, true},
+		{"custom", func(l *leaseConfig) {
+			l.name = "gpu-role-controller"
+			l.leaseDuration = 60 * time.Second
+			l.renewDeadline = 40 * time.Second
+			l.retryPeriod = 5 * time.Second
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := valid
+			tt.mutate(&l)
+			if err := l.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithLease_SetsLeaseConfig(t *testing.T) {
+	i := &Informer{}
+	WithLeaseName("gpu")(i)
+	WithIdentity("pod-1")(i)
+	WithLeaseDuration(30 * time.Second)(i)
+	WithRenewDeadline(20 * time.Second)(i)
+	WithRetryPeriod(4 * time.Second)(i)
+	want := leaseConfig{
+		name:          "gpu",
+		identity:      "pod-1",
+		leaseDuration: 30 * time.Second,
+		renewDeadline: 20 * time.Second,
+		retryPeriod:   4 * time.Second,
+	}
+	if i.lease != want {
+		t.Errorf("expected %+v, got %+v", want, i.lease)
+	}
 }
@@ -0,0 +1,202 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/mapping"
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// mappingStatusInterval is how often the status of the NodeRoleMapping resources is updated.
+	mappingStatusInterval = 30 * time.Second
+	// mappingSyncWarnInterval is how often a mapping cache that has not synced is reported.
+	mappingSyncWarnInterval = time.Minute
+)
+
+var mappingsSyncedGauge = metric.NewGauge("rolesetter_mappings_synced", "1 once the NodeRoleMapping resources are loaded, 0 while they are loading")
+
+// WithWatchMappings sets whether NodeRoleMapping resources are watched and merged into the rules.
+func WithWatchMappings(watch bool) Option {
+	return func(i *Informer) {
+		i.watchMappings = watch
+	}
+}
+
+// mappingWatcher keeps the handler's mappings in sync with the NodeRoleMapping resources
+// and reports their status.
+type mappingWatcher struct {
+	informer  *Informer
+	factory   dynamicinformer.DynamicSharedInformerFactory
+	store     cache.Store
+	hasSynced cache.InformerSynced
+	changed   chan struct{}
+	// invalid holds the validation error of each mapping that is ignored
+	invalid map[string]error
+}
+
+// startMappings starts the NodeRoleMapping informer without waiting for it to sync; run loads the mappings once it has.
+func (i *Informer) startMappings(ctx context.Context) (*mappingWatcher, error) {
+	factory := dynamicinformer.NewDynamicSharedInformerFactory(i.dynamic, resyncInterval)
+	inf := factory.ForResource(mapping.GroupVersionResource).Informer()
+
+	w := &mappingWatcher{
+		informer:  i,
+		factory:   factory,
+		store:     inf.GetStore(),
+		hasSynced: inf.HasSynced,
+		changed:   make(chan struct{}, 1),
+	}
+	notify := func() {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+		}
+	}
+	if _, err := inf.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) { notify() },
+		// Status updates and resyncs don't change the spec, only a new generation does
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			o, oldOK := oldObj.(metav1.Object)
+			n, newOK := newObj.(metav1.Object)
+			if oldOK && newOK && o.GetGeneration() == n.GetGeneration() {
+				return
+			}
+			notify()
+		},
+		DeleteFunc: func(interface{}) { notify() },
+	}); err != nil {
+		return nil, fmt.Errorf("failed to add mapping event handler: %w", err)
+	}
+
+	mappingsSyncedGauge.Set(0)
+	factory.Start(ctx.Done())
+	return w, nil
+}
+
+// waitForSync waits for the mapping cache to sync, reporting it every mappingSyncWarnInterval
+// until it does, e.g. when the NodeRoleMapping CRD is not installed. It returns false once the context is done.
+func (w *mappingWatcher) waitForSync(ctx context.Context) bool {
+	start := time.Now()
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, mappingSyncWarnInterval)
+		synced := cache.WaitForCacheSync(syncCtx.Done(), w.hasSynced)
+		cancel()
+		if synced {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		w.informer.logger.Warn("NodeRoleMapping resources have not synced, mappings are not applied and no role label is removed; check that the rolesetter.io CRD is installed",
+			zap.Duration("waited", time.Since(start).Round(time.Second)),
+		)
+	}
+}
+
+// run loads the mappings once they have synced, then applies mapping changes and updates
+// their status until the context is done, and returns once the mapping informer has stopped.
+func (w *mappingWatcher) run(ctx context.Context) {
+	defer w.factory.Shutdown()
+	if !w.waitForSync(ctx) {
+		return
+	}
+	w.load()
+
+	ticker := time.NewTicker(mappingStatusInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.changed:
+			w.load()
+			w.updateStatus(ctx)
+		case <-ticker.C:
+			w.updateStatus(ctx)
+		}
+	}
+}
+
+// load converts the cached resources into mappings and swaps them into the running controller.
+// Invalid mappings are left out and reported in their status.
+func (w *mappingWatcher) load() {
+	var mappings []role.Mapping
+	invalid := map[string]error{}
+	for _, obj := range w.store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		m, err := mapping.FromUnstructured(u)
+		if err != nil {
+			invalid[u.GetName()] = err
+			continue
+		}
+		rm, err := m.RoleMapping()
+		if err != nil {
+			invalid[m.Name] = err
+			continue
+		}
+		mappings = append(mappings, rm)
+	}
+	sort.Slice(mappings, func(a, b int) bool { return mappings[a].Name < mappings[b].Name })
+
+	i := w.informer
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	w.invalid = invalid
+	i.mappings = mappings
+	i.mappingsSynced = true
+	mappingsSyncedGauge.Set(1)
+	for name, err := range invalid {
+		i.logger.Error("ignoring invalid mapping", zap.String("mapping", name), zap.Error(err))
+	}
+	i.logger.Info("mappings loaded", zap.Int("mappings", len(mappings)), zap.Int("invalid", len(invalid)))
+
+	if i.ctrl == nil {
+		return
+	}
+	handler, err := i.newHandler()
+	if err != nil {
+		i.logger.Error("failed to create role handler with mappings", zap.Error(err))
+		return
+	}
+	i.ctrl.setHandler(handler)
+	i.ctrl.enqueueAll()
+}
+
+// updateStatus writes the matched nodes, conflicts and validation error of every mapping.
+func (w *mappingWatcher) updateStatus(ctx context.Context) {
+	i := w.informer
+	i.mu.Lock()
+	invalid := w.invalid
+	i.mu.Unlock()
+
+	for _, obj := range w.store.List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			continue
+		}
+		m, err := mapping.FromUnstructured(u)
+		if err != nil {
+			continue
+		}
+		matched, conflicts := i.mappingStore.Status(m.Name)
+		status := mapping.NewStatus(m, matched, conflicts, invalid[m.Name])
+		if err := mapping.UpdateStatus(ctx, i.dynamic, m, status); err != nil {
+			i.logger.Error("failed to update mapping status", zap.String("mapping", m.Name), zap.Error(err))
+		}
+	}
+}
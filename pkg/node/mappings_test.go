@@ -0,0 +1,181 @@
+package node
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/mapping"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func getTestMapping(name string, source map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": mapping.Group + "/" + mapping.Version,
+		"kind":       mapping.Kind,
+		"metadata":   map[string]interface{}{"name": name},
+		"spec":       map[string]interface{}{"source": source},
+	}}
+}
+
+func TestInformer_Mappings(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{mapping.GroupVersionResource: mapping.Kind + "List"},
+		getTestMapping("team", map[string]interface{}{"label": "team"}),
+		getTestMapping("invalid", map[string]interface{}{"label": "team", "annotation": "team"}),
+	)
+
+	i := newReloadInformer()
+	i.labels = nil
+	i.watchMappings = true
+	i.mappingStore = role.NewMappingStore()
+	i.dynamic = client
+	n := getTestNode("n1", "1", map[string]string{"team": "ml"})
+	i.clientset = fake.NewClientset(n)
+
+	c := newTestController(t, &testPatcher{}, n)
+	defer c.queue.ShutDown()
+	i.ctrl = c
+	before := c.handler.Load()
+
+	w, err := i.startMappings(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.handler.Load() != before || i.mappingsSynced {
+		t.Fatal("expected mappings to load only once synced")
+	}
+	if !w.waitForSync(ctx) {
+		t.Fatal("expected mapping cache to sync")
+	}
+	w.load()
+	if !i.mappingsSynced {
+		t.Error("expected mappings to be marked synced")
+	}
+	if len(i.mappings) != 1 || i.mappings[0].Name != "team" {
+		t.Fatalf("expected only the valid mapping, got %v", i.mappings)
+	}
+	if _, ok := w.invalid["invalid"]; !ok {
+		t.Error("expected invalid mapping to be reported")
+	}
+	if c.handler.Load() == before {
+		t.Error("expected handler to be swapped")
+	}
+	if n := c.queue.Len(); n != 1 {
+		t.Errorf("expected node to be enqueued, got %d", n)
+	}
+
+	// Resolving the node records the mapping match for the status
+	if err := c.handler.Load().EnsureRole(ctx, n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.updateStatus(ctx)
+
+	for name, want := range map[string]mapping.NodeRoleMappingStatus{
+		"team":    {MatchedNodes: 1},
+		"invalid": {Error: w.invalid["invalid"].Error()},
+	} {
+		u, err := client.Resource(mapping.GroupVersionResource).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m, err := mapping.FromUnstructured(u)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if m.Status.MatchedNodes != want.MatchedNodes || m.Status.Error != want.Error {
+			t.Errorf("mapping %s: expected status %+v, got %+v", name, want, m.Status)
+		}
+	}
+}
+
+func TestInformer_MappingsNotInstalled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The LIST fails with NotFound, as it does when the CRD is not installed
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{mapping.GroupVersionResource: mapping.Kind + "List"})
+	client.PrependReactor("list", mapping.GroupVersionResource.Resource, func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(mapping.GroupVersionResource.GroupResource(), "")
+	})
+	i := newReloadInformer()
+	i.watchMappings = true
+	i.dynamic = client
+
+	w, err := i.startMappings(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.waitForSync(ctx) {
+		t.Fatal("expected mapping cache not to sync")
+	}
+	if i.mappingsSynced {
+		t.Error("expected mappings not to be marked synced")
+	}
+}
+
+func TestInformer_MappingUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	team := getTestMapping("team", map[string]interface{}{"label": "team"})
+	team.SetGeneration(1)
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{mapping.GroupVersionResource: mapping.Kind + "List"}, team)
+
+	i := newReloadInformer()
+	i.watchMappings = true
+	i.dynamic = client
+	w, err := i.startMappings(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !w.waitForSync(ctx) {
+		t.Fatal("expected mapping cache to sync")
+	}
+	// The initial add
+	select {
+	case <-w.changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected the added mapping to be reported")
+	}
+
+	update := func(u *unstructured.Unstructured) {
+		t.Helper()
+		if _, err := client.Resource(mapping.GroupVersionResource).Update(ctx, u, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// A status-only update keeps the generation
+	status := team.DeepCopy()
+	status.Object["status"] = map[string]interface{}{"matchedNodes": int64(1)}
+	update(status)
+	select {
+	case <-w.changed:
+		t.Fatal("expected a status update not to reload the mappings")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	spec := status.DeepCopy()
+	spec.SetGeneration(2)
+	spec.Object["spec"] = map[string]interface{}{"source": map[string]interface{}{"label": "pool"}}
+	update(spec)
+	select {
+	case <-w.changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a spec update to reload the mappings")
+	}
+}
@@ -0,0 +1,136 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/config"
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// configReloadInterval is how often the config file is checked for changes.
+// Kubelet takes up to a minute to update a mounted ConfigMap, so this adds little delay.
+const configReloadInterval = 10 * time.Second
+
+var (
+	configGenerationGauge = metric.NewGauge("rolesetter_config_generation", "Generation of the configuration in use, incremented on every successful reload")
+	configReloadErrors    = metric.NewCounter("rolesetter_config_reload_errors_total", "Total number of failed configuration reloads")
+)
+
+// WithConfigFile sets the config file that is watched and reloaded when it changes.
+func WithConfigFile(path string) Option {
+	return func(i *Informer) {
+		i.configFile = path
+	}
+}
+
+// configGeneration returns the generation of the configuration in use.
+func (i *Informer) configGeneration() int64 {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.generation
+}
+
+// watchConfig reloads the config file whenever it changes until the context is done.
+func (i *Informer) watchConfig(ctx context.Context) {
+	i.logger.Info("watching config file", zap.String("path", i.configFile))
+	config.Watch(ctx, i.configFile, configReloadInterval, i.getenv,
+		func(cfg *config.Config) {
+			if err := i.reload(cfg); err != nil {
+				configReloadErrors.Increment()
+				i.logger.Error("failed to reload config, keeping the current one", zap.Error(err))
+			}
+		},
+		func(err error) {
+			configReloadErrors.Increment()
+			i.logger.Error("failed to load changed config, keeping the current one", zap.Error(err))
+		},
+	)
+}
+
+// reload applies the role settings of the config. The new handler is built and validated first,
+// then swapped into the running controller, which re-reconciles every node under the new rules.
+// Settings that shape the informer, server or leader election only take effect after a restart.
+func (i *Informer) reload(cfg *config.Config) error {
+	next := defaultInformer()
+	for _, opt := range configOptions(cfg) {
+		opt(next)
+	}
+	if _, err := labels.Parse(next.selector); err != nil {
+		return fmt.Errorf("invalid node selector: %w", err)
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	restart := i.restartRequired(next)
+
+	next.logger, next.plans, next.health, next.clientset = i.logger, i.plans, i.health, i.clientset
+	next.recorder, next.overrides = i.recorder, i.overrides
+	next.generation = i.generation + 1
+	next.watchMappings, next.mappings, next.mappingStore = i.watchMappings, i.mappings, i.mappingStore
+	next.mappingsSynced = i.mappingsSynced
+	// The labels the current manager owns would no longer be seen as owned under another one
+	next.manager = i.manager
+	handler, err := next.newHandler()
+	if err != nil {
+		return fmt.Errorf("failed to create role handler: %w", err)
+	}
+
+	if len(restart) > 0 {
+		i.logger.Warn("config changes that require a restart were not applied", zap.Strings("settings", restart))
+	}
+
+	i.labels, i.labelMode, i.separator, i.rules = next.labels, next.labelMode, next.separator, next.rules
+	i.transforms, i.defaultRole, i.normalize = next.transforms, next.defaultRole, next.normalize
+	i.protection, i.trust, i.taints, i.startup = next.protection, next.trust, next.taints, next.startup
+	i.metadata, i.status = next.metadata, next.status
+	i.replace, i.gc = next.replace, next.gc
+	i.outputs, i.force, i.dryRun = next.outputs, next.force, next.dryRun
+	i.generation = next.generation
+	configGenerationGauge.Set(float64(i.generation))
+
+	i.logger.Info("config reloaded",
+		zap.Int64("generation", i.generation),
+		zap.Strings("labels", i.labels),
+		zap.String("labelMode", string(i.labelMode)),
+		zap.Int("rules", len(i.rules)),
+		zap.Bool("replace", i.replace),
+		zap.Bool("gc", i.gc),
+		zap.String("fieldManager", i.manager),
+		zap.Bool("dryRun", i.dryRun),
+	)
+
+	if i.ctrl != nil {
+		i.ctrl.setHandler(handler)
+		i.ctrl.enqueueAll()
+	}
+	return nil
+}
+
+// restartRequired lists the settings that differ in next but can't be changed at runtime.
+func (i *Informer) restartRequired(next *Informer) []string {
+	var changed []string
+	if next.workers != i.workers {
+		changed = append(changed, "controller.workers")
+	}
+	if next.port != i.port {
+		changed = append(changed, "server.port")
+	}
+	if next.selector != i.selector {
+		changed = append(changed, "scope.nodeSelector")
+	}
+	if next.watchMappings != i.watchMappings {
+		changed = append(changed, "mappings.watchResources")
+	}
+	if next.manager != i.manager {
+		changed = append(changed, "apply.fieldManager")
+	}
+	if next.namespace != i.namespace || next.lease != i.lease {
+		changed = append(changed, "leaderElection")
+	}
+	return changed
+}
@@ -0,0 +1,99 @@
+package node
+
+import (
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/config"
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newReloadInformer() *Informer {
+	i := defaultInformer()
+	i.logger = logger.GetTestLogger()
+	i.labels = []string{"nodeGroup"}
+	i.plans = role.NewPlanStore()
+	i.health = newHealth()
+	i.clientset = fake.NewClientset()
+	return i
+}
+
+func TestInformer_Reload(t *testing.T) {
+	i := newReloadInformer()
+	c := newTestController(t, &testPatcher{},
+		getTestNode("n1", "1", map[string]string{"nodeGroup": "worker"}),
+		getTestNode("n2", "1", nil),
+	)
+	defer c.queue.ShutDown()
+	i.ctrl = c
+	before := c.handler.Load()
+
+	cfg := &config.Config{
+		APIVersion: config.APIVersion,
+		Sources:    config.Sources{Labels: []string{"karpenter.sh/nodepool"}, Mode: "all"},
+		Mappings:   config.Mappings{GC: true},
+		Controller: config.Controller{Workers: 8},
+		Apply:      config.Apply{FieldManager: "other"},
+	}
+	if err := i.reload(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(i.labels) != 1 || i.labels[0] != "karpenter.sh/nodepool" || i.labelMode != role.SourceModeAll || !i.gc {
+		t.Errorf("role settings not reloaded: %+v", i)
+	}
+	if i.workers != workersDefault {
+		t.Errorf("expected workers to require a restart, got %d", i.workers)
+	}
+	if i.manager != fieldManagerDefault {
+		t.Errorf("expected field manager to require a restart, got %s", i.manager)
+	}
+	if i.configGeneration() != 2 {
+		t.Errorf("expected generation 2, got %d", i.configGeneration())
+	}
+	if c.handler.Load() == before {
+		t.Error("expected handler to be swapped")
+	}
+	if c.queue.Len() != 2 {
+		t.Errorf("expected all nodes to be enqueued, got %d", c.queue.Len())
+	}
+}
+
+func TestInformer_ReloadInvalid(t *testing.T) {
+	i := newReloadInformer()
+
+	cfg := &config.Config{
+		APIVersion: config.APIVersion,
+		Mappings: config.Mappings{Rules: []config.Rule{
+			{Expression: "node.", Roles: []string{"broken"}},
+		}},
+	}
+	if err := i.reload(cfg); err == nil {
+		t.Fatal("expected error for invalid rule")
+	}
+	if len(i.labels) != 1 || i.labels[0] != "nodeGroup" || len(i.rules) != 0 {
+		t.Errorf("expected role settings to be kept, got labels %v, rules %v", i.labels, i.rules)
+	}
+	if i.configGeneration() != 1 {
+		t.Errorf("expected generation to be kept, got %d", i.configGeneration())
+	}
+}
+
+func TestInformer_RestartRequired(t *testing.T) {
+	i := defaultInformer()
+	next := defaultInformer()
+	if got := i.restartRequired(next); len(got) != 0 {
+		t.Errorf("expected no changes, got %v", got)
+	}
+
+	next.port = 9090
+	next.selector = "pool=gpu"
+	next.lease.name = "other"
+	next.watchMappings = true
+	next.manager = "other"
+	got := i.restartRequired(next)
+	if len(got) != 5 {
+		t.Errorf("expected 5 changes, got %v", got)
+	}
+}
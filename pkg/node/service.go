@@ -4,11 +4,11 @@ import (
 	"context"
 	"os"
 	"os/signal"
-	"strconv"
-	"strings"
 	"syscall"
 
+	"github.com/mchmarny/rolesetter/pkg/config"
 	"github.com/mchmarny/rolesetter/pkg/logger"
+	"github.com/mchmarny/rolesetter/pkg/role"
 	"go.uber.org/zap"
 )
 
@@ -28,39 +28,14 @@ func InformNodeRoles() {
 		cancel()
 	}()
 
-	// Read environment variables for role label and server port
-	roleLabel := os.Getenv("ROLE_LABEL")
-	if roleLabel == "" {
-		logger.Fatal("environment variable ROLE_LABEL is not set")
-	}
-
-	serverPort := os.Getenv("SERVER_PORT")
-	if serverPort == "" {
-		serverPort = "8080" // Default port if not set
-	}
-
-	roleLabelReplace := strings.TrimSpace(strings.ToLower(os.Getenv("ROLE_LABEL_REPLACE")))
-	replace := roleLabelReplace == "true" || roleLabelReplace == "1" || roleLabelReplace == "yes"
-
-	namespace := os.Getenv("NAMESPACE")
-
-	// parse integer port
-	port, err := strconv.Atoi(serverPort)
-	if err != nil || port <= 0 {
-		logger.Fatal("invalid SERVER_PORT environment variable", zap.Error(err))
-	}
-
-	// Create a new informer instance
-	opts := []Option{
-		WithLogger(logger),
-		WithLabel(roleLabel),
-		WithPort(port),
-		WithReplace(replace),
-	}
-	if namespace != "" {
-		opts = append(opts, WithNamespace(namespace))
+	// The config file is optional; environment variables override its values
+	configFile := os.Getenv("CONFIG_FILE")
+	cfg, err := config.Load(configFile, os.Getenv)
+	if err != nil {
+		logger.Fatal("invalid configuration", zap.Error(err))
 	}
 
+	opts := append([]Option{WithLogger(logger), WithConfigFile(configFile)}, configOptions(cfg)...)
 	inf, err := NewInformer(opts...)
 	if err != nil {
 		logger.Fatal("failed to create informer", zap.Error(err))
@@ -71,3 +46,55 @@ func InformNodeRoles() {
 		logger.Fatal("failed to run informer", zap.Error(err))
 	}
 }
+
+// configOptions converts the config into Informer options, leaving the defaults for unset values.
+func configOptions(cfg *config.Config) []Option {
+	opts := []Option{
+		WithLabels(cfg.Sources.Labels...),
+		WithSeparator(cfg.Sources.Separator),
+		WithRules(cfg.Rules()...),
+		WithTransforms(cfg.Transforms()...),
+		WithDefaultRole(cfg.Roles.Default),
+		WithNormalization(cfg.Normalization()),
+		WithProtection(cfg.Protection()),
+		WithTrust(cfg.Trust()),
+		WithTaints(cfg.Taints()),
+		WithStartupTaint(cfg.Startup()),
+		WithMetadata(cfg.Metadata()),
+		WithStatus(cfg.NodeStatus()),
+		WithReplace(cfg.Mappings.Replace),
+		WithGC(cfg.Mappings.GC),
+		WithWatchMappings(cfg.Mappings.WatchResources),
+		WithOutputs(cfg.Outputs()...),
+		WithForce(cfg.Apply.Force),
+		WithDryRun(cfg.Apply.DryRun),
+		WithNodeSelector(cfg.Scope.NodeSelector),
+		WithNamespace(cfg.LeaderElection.Namespace),
+		WithIdentity(cfg.LeaderElection.Identity),
+	}
+	if cfg.Sources.Mode != "" {
+		opts = append(opts, WithLabelMode(role.SourceMode(cfg.Sources.Mode)))
+	}
+	if cfg.Apply.FieldManager != "" {
+		opts = append(opts, WithFieldManager(cfg.Apply.FieldManager))
+	}
+	if cfg.Controller.Workers > 0 {
+		opts = append(opts, WithWorkers(cfg.Controller.Workers))
+	}
+	if cfg.Server.Port > 0 {
+		opts = append(opts, WithPort(cfg.Server.Port))
+	}
+	if cfg.LeaderElection.LeaseName != "" {
+		opts = append(opts, WithLeaseName(cfg.LeaderElection.LeaseName))
+	}
+	if d := cfg.LeaderElection.LeaseDuration.Duration; d > 0 {
+		opts = append(opts, WithLeaseDuration(d))
+	}
+	if d := cfg.LeaderElection.RenewDeadline.Duration; d > 0 {
+		opts = append(opts, WithRenewDeadline(d))
+	}
+	if d := cfg.LeaderElection.RetryPeriod.Duration; d > 0 {
+		opts = append(opts, WithRetryPeriod(d))
+	}
+	return opts
+}
@@ -0,0 +1,41 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/config"
+	"github.com/mchmarny/rolesetter/pkg/role"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigOptions(t *testing.T) {
+	cfg := &config.Config{
+		APIVersion: config.APIVersion,
+		Sources:    config.Sources{Labels: []string{"nodeGroup"}, Mode: "all"},
+		Scope:      config.Scope{NodeSelector: "pool=gpu"},
+		LeaderElection: config.LeaderElection{
+			LeaseName:     "gpu-role-controller",
+			LeaseDuration: metav1.Duration{Duration: 30 * time.Second},
+		},
+	}
+
+	i := &Informer{
+		port:    servicePortDefault,
+		workers: workersDefault,
+		lease:   leaseConfig{name: leaseNameDefault, leaseDuration: leaseDurationDefault},
+	}
+	for _, opt := range configOptions(cfg) {
+		opt(i)
+	}
+
+	if i.labelMode != role.SourceModeAll || i.selector != "pool=gpu" {
+		t.Errorf("unexpected informer: %+v", i)
+	}
+	if i.port != servicePortDefault || i.workers != workersDefault {
+		t.Errorf("expected unset values to keep defaults, got port %d, workers %d", i.port, i.workers)
+	}
+	if i.lease.name != "gpu-role-controller" || i.lease.leaseDuration != 30*time.Second {
+		t.Errorf("unexpected lease config: %+v", i.lease)
+	}
+}
@@ -0,0 +1,25 @@
+package role
+
+import (
+	"errors"
+)
+
+// permanentError marks an error that retrying the same reconcile will not resolve
+// (e.g. Forbidden, Invalid or a field manager conflict).
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// IsPermanent reports whether the error returned by EnsureRole cannot be resolved by retrying.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
@@ -0,0 +1,27 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestIsPermanent(t *testing.T) {
+	base := errors.New("forbidden")
+	pe := &permanentError{err: base}
+	if !IsPermanent(pe) {
+		t.Error("expected permanent error")
+	}
+	if !IsPermanent(fmt.Errorf("wrapped: %w", pe)) {
+		t.Error("expected wrapped permanent error")
+	}
+	if !errors.Is(pe, base) {
+		t.Error("expected permanent error to unwrap to its cause")
+	}
+	if pe.Error() != base.Error() {
+		t.Errorf("unexpected message: %s", pe.Error())
+	}
+	if IsPermanent(base) || IsPermanent(nil) {
+		t.Error("expected non-permanent error")
+	}
+}
@@ -0,0 +1,220 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const mappingSource = "mapping:"
+
+// Mapping resolves roles for the nodes matching its selector from exactly one source:
+// the value of a label, the value of an annotation, or the roles emitted when a CEL expression matches.
+type Mapping struct {
+	// Name identifies the mapping in logs, metrics and status.
+	Name string
+	// Selector limits the nodes the mapping applies to; nil matches every node.
+	Selector labels.Selector
+	// Label is the label key whose value becomes the role.
+	Label string
+	// Annotation is the annotation key whose value becomes the role.
+	Annotation string
+	// Expression is a CEL expression evaluated like a Rule.
+	Expression string
+	// Roles are the roles emitted when the expression evaluates to true.
+	Roles []string
+}
+
+// compiledMapping is a Mapping with its compiled expression, if any.
+type compiledMapping struct {
+	Mapping
+	rule *compiledRule
+}
+
+// ValidateMapping checks that the mapping has exactly one source and compiles its expression.
+func ValidateMapping(m Mapping) error {
+	_, err := compileMapping(m)
+	return err
+}
+
+// compileMappings compiles the mappings, reporting all errors found.
+func compileMappings(mappings []Mapping) ([]compiledMapping, error) {
+	var errs []error
+	compiled := make([]compiledMapping, 0, len(mappings))
+	for _, m := range mappings {
+		cm, err := compileMapping(m)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		compiled = append(compiled, cm)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return compiled, nil
+}
+
+func compileMapping(m Mapping) (compiledMapping, error) {
+	if m.Name == "" {
+		return compiledMapping{}, fmt.Errorf("mapping name must not be empty")
+	}
+
+	sources := 0
+	for _, s := range []string{m.Label, m.Annotation, m.Expression} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return compiledMapping{}, fmt.Errorf("mapping %s: exactly one of label, annotation or expression must be specified", m.Name)
+	}
+
+	cm := compiledMapping{Mapping: m}
+	if m.Expression == "" {
+		if len(m.Roles) > 0 {
+			return compiledMapping{}, fmt.Errorf("mapping %s: roles are only used with an expression", m.Name)
+		}
+		return cm, nil
+	}
+
+	rules, err := compileRules([]Rule{{Name: m.Name, Expression: m.Expression, Roles: m.Roles}})
+	if err != nil {
+		return compiledMapping{}, fmt.Errorf("mapping %s: %w", m.Name, err)
+	}
+	rules[0].source = mappingSource + m.Name
+	cm.rule = &rules[0]
+	return cm, nil
+}
+
+// evaluateMappings returns the roles resolved by the mappings for the node,
+// and the names of the mappings that matched it. Label and annotation values are subject to
+// the same trust as source labels and override annotations; untrusted ones are reported and skipped.
+func (h *CacheResourceHandler) evaluateMappings(n *corev1.Node) ([]Resolution, map[string]bool) {
+	var res []Resolution
+	matched := map[string]bool{}
+	var rules []compiledRule
+	for _, m := range h.compiledMappings {
+		if m.Selector != nil && !m.Selector.Matches(labels.Set(n.Labels)) {
+			continue
+		}
+		switch {
+		case m.rule != nil:
+			rules = append(rules, *m.rule)
+		case m.Label != "":
+			v := n.Labels[m.Label]
+			if v == "" {
+				continue
+			}
+			if !h.trust.trusted(n, m.Label) {
+				h.reportUntrusted(n, m.Label, v)
+				continue
+			}
+			res = append(res, Resolution{Role: v, Source: mappingSource + m.Name})
+			matched[m.Name] = true
+		case m.Annotation != "":
+			v := n.Annotations[m.Annotation]
+			if v == "" {
+				continue
+			}
+			if !h.trust.trustedAnnotation(n, m.Annotation) {
+				h.reportUntrusted(n, m.Annotation, v)
+				continue
+			}
+			res = append(res, Resolution{Role: v, Source: mappingSource + m.Name})
+			matched[m.Name] = true
+		}
+	}
+
+	for _, r := range evaluateRules(n, rules, h.logger) {
+		res = append(res, r)
+		matched[strings.TrimPrefix(r.Source, mappingSource)] = true
+	}
+	return res, matched
+}
+
+// mappingName returns the mapping that produced the resolution, if any.
+func mappingName(r Resolution) (string, bool) {
+	return strings.CutPrefix(r.Source, mappingSource)
+}
+
+// MappingStore tracks, for each mapping, the nodes it matched and the conflicts it has on them.
+// It outlives handlers so the status survives configuration reloads.
+type MappingStore struct {
+	mu sync.RWMutex
+	// nodes maps a mapping name to the nodes it matched
+	nodes map[string]map[string]bool
+	// conflicts maps a mapping name to the conflict messages by node
+	conflicts map[string]map[string][]string
+}
+
+// NewMappingStore creates an empty MappingStore.
+func NewMappingStore() *MappingStore {
+	return &MappingStore{
+		nodes:     map[string]map[string]bool{},
+		conflicts: map[string]map[string][]string{},
+	}
+}
+
+// observe records the mappings that matched the node, clearing its previous matches and conflicts.
+func (s *MappingStore) observe(node string, matched map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(node)
+	for name := range matched {
+		if s.nodes[name] == nil {
+			s.nodes[name] = map[string]bool{}
+		}
+		s.nodes[name][node] = true
+	}
+}
+
+// conflict records a conflict of the mapping on the node.
+func (s *MappingStore) conflict(name, node, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conflicts[name] == nil {
+		s.conflicts[name] = map[string][]string{}
+	}
+	s.conflicts[name][node] = append(s.conflicts[name][node], msg)
+}
+
+// Forget drops the matches and conflicts recorded for the node.
+func (s *MappingStore) Forget(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.forget(node)
+}
+
+// forget removes the node; callers must hold the lock.
+func (s *MappingStore) forget(node string) {
+	for name, nodes := range s.nodes {
+		delete(nodes, node)
+		if len(nodes) == 0 {
+			delete(s.nodes, name)
+		}
+	}
+	for name, nodes := range s.conflicts {
+		delete(nodes, node)
+		if len(nodes) == 0 {
+			delete(s.conflicts, name)
+		}
+	}
+}
+
+// Status returns the number of nodes the mapping matched and its conflicts, sorted.
+func (s *MappingStore) Status(name string) (int, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var conflicts []string
+	for _, msgs := range s.conflicts[name] {
+		conflicts = append(conflicts, msgs...)
+	}
+	sort.Strings(conflicts)
+	return len(s.nodes[name]), conflicts
+}
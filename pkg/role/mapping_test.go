@@ -0,0 +1,170 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestValidateMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping Mapping
+		wantErr bool
+	}{
+		{"label", Mapping{Name: "pool", Label: "pool"}, false},
+		{"annotation", Mapping{Name: "team", Annotation: "example.com/team"}, false},
+		{"expression", Mapping{Name: "gpu", Expression: "'gpu' in node.metadata.labels", Roles: []string{"gpu"}}, false},
+		{"no name", Mapping{Label: "pool"}, true},
+		{"no source", Mapping{Name: "none"}, true},
+		{"two sources", Mapping{Name: "both", Label: "pool", Annotation: "team"}, true},
+		{"label with roles", Mapping{Name: "pool", Label: "pool", Roles: []string{"x"}}, true},
+		{"expression without roles", Mapping{Name: "gpu", Expression: "true"}, true},
+		{"invalid expression", Mapping{Name: "bad", Expression: "node.", Roles: []string{"x"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMapping(tt.mapping); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateMapping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateMappings(t *testing.T) {
+	gpuPool := labels.SelectorFromSet(labels.Set{"pool": "gpu"})
+	h, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(), WithMappings(
+		Mapping{Name: "group", Label: "nodeGroup"},
+		Mapping{Name: "team", Selector: gpuPool, Annotation: "example.com/team"},
+		Mapping{Name: "gpu", Selector: gpuPool, Expression: "node.metadata.labels['pool'] == 'gpu'", Roles: []string{"accelerated"}},
+	))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := getTestNode("n1", map[string]string{"nodeGroup": "worker", "pool": "gpu"})
+	n.Annotations = map[string]string{"example.com/team": "ml"}
+	res, matched := h.evaluateMappings(n)
+	if len(res) != 3 || !matched["group"] || !matched["team"] || !matched["gpu"] {
+		t.Errorf("expected all mappings to match, got %v, %v", res, matched)
+	}
+
+	other := getTestNode("n2", map[string]string{"nodeGroup": "worker", "pool": "cpu"})
+	other.Annotations = map[string]string{"example.com/team": "ml"}
+	res, matched = h.evaluateMappings(other)
+	if len(res) != 1 || res[0].Role != "worker" || res[0].Source != "mapping:group" || len(matched) != 1 {
+		t.Errorf("expected only the unscoped mapping to match, got %v, %v", res, matched)
+	}
+}
+
+func TestMappingStore(t *testing.T) {
+	s := NewMappingStore()
+	s.observe("n1", map[string]bool{"a": true, "b": true})
+	s.observe("n2", map[string]bool{"a": true})
+	s.conflict("a", "n2", "conflict on n2")
+
+	if c, conflicts := s.Status("a"); c != 2 || len(conflicts) != 1 {
+		t.Errorf("unexpected status for a: %d, %v", c, conflicts)
+	}
+
+	// Observing again replaces the previous matches and conflicts of the node
+	s.observe("n2", map[string]bool{"b": true})
+	if c, conflicts := s.Status("a"); c != 1 || len(conflicts) != 0 {
+		t.Errorf("unexpected status for a after observe: %d, %v", c, conflicts)
+	}
+
+	s.Forget("n1")
+	s.Forget("n2")
+	if c, _ := s.Status("b"); c != 0 {
+		t.Errorf("expected no matches after forget, got %d", c)
+	}
+}
+
+func TestEnsureRole_MappingConflicts(t *testing.T) {
+	store := NewMappingStore()
+	patcher := func(_ context.Context, _ string, _ types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		return nil, nil
+	}
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("nodeGroup"),
+		WithMappings(Mapping{Name: "pool", Label: "pool"}),
+		WithMappingStore(store),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	n := getTestNode("n1", map[string]string{"nodeGroup": "gpu", "pool": "gpu"})
+	if err := h.EnsureRole(context.Background(), n); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count, conflicts := store.Status("pool")
+	if count != 1 || len(conflicts) != 1 || !strings.Contains(conflicts[0], "nodeGroup") {
+		t.Errorf("expected 1 match with a conflict on nodeGroup, got %d, %v", count, conflicts)
+	}
+}
+
+func TestEnsureRole_MappingsPending(t *testing.T) {
+	for _, pending := range []bool{true, false} {
+		var got corev1.Node
+		patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("failed to decode patch: %v", err)
+			}
+			return nil, nil
+		}
+		h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+			WithSources("nodeGroup"),
+			WithGC(true),
+			WithMappingStore(NewMappingStore()),
+			WithMappingsPending(pending),
+		)
+		if err != nil {
+			t.Fatalf("failed to create handler: %v", err)
+		}
+
+		// The role a mapping resolved is owned but not resolved until the mappings load
+		n := withOwnedLabels(getTestNode("n1", map[string]string{"nodeGroup": "gpu", rolePrefix + "team": ""}),
+			fieldManagerDefault, rolePrefix+"team")
+		if err := h.EnsureRole(context.Background(), n); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, kept := got.Labels[rolePrefix+"team"]; kept != pending {
+			t.Errorf("pending %v: expected mapped role kept %v, got %v", pending, pending, got.Labels)
+		}
+	}
+}
+
+func TestEvaluateMappings_Trust(t *testing.T) {
+	h, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(),
+		WithTrust(Trust{Enabled: true, Managers: []string{"kubectl-label", "kubectl-annotate"}}),
+		WithMappings(
+			Mapping{Name: "group", Label: "nodeGroup"},
+			Mapping{Name: "team", Annotation: "example.com/team"},
+		),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Set by the node itself
+	n := getTestNode("n1", map[string]string{"nodeGroup": "worker"})
+	n.Annotations = map[string]string{"example.com/team": "ml"}
+	if res, matched := h.evaluateMappings(n); len(res) != 0 || len(matched) != 0 {
+		t.Errorf("expected untrusted mapping sources to be skipped, got %v, %v", res, matched)
+	}
+
+	// Set by trusted managers
+	n = withOwnedAnnotations(withOwnedLabels(n, "kubectl-label", "nodeGroup"), "kubectl-annotate", "example.com/team")
+	if res, matched := h.evaluateMappings(n); len(res) != 2 || !matched["group"] || !matched["team"] {
+		t.Errorf("expected trusted mapping sources to match, got %v, %v", res, matched)
+	}
+}
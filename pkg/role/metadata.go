@@ -0,0 +1,76 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Metadata are the extra labels and annotations written to the nodes with a role.
+type Metadata struct {
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// ValidateMetadata checks the extra labels and annotations of every role, reporting all errors found.
+func ValidateMetadata(metadata map[string]Metadata) error {
+	var errs []error
+	for _, r := range sortedKeys(metadata) {
+		md := metadata[r]
+		for _, k := range sortedKeys(md.Labels) {
+			if e := validation.IsQualifiedName(k); len(e) > 0 {
+				errs = append(errs, fmt.Errorf("role %s: invalid label key %q: %s", r, k, strings.Join(e, "; ")))
+			}
+			if e := validation.IsValidLabelValue(md.Labels[k]); len(e) > 0 {
+				errs = append(errs, fmt.Errorf("role %s: invalid label value %q for %s: %s", r, md.Labels[k], k, strings.Join(e, "; ")))
+			}
+		}
+		for _, k := range sortedKeys(md.Annotations) {
+			if e := validation.IsQualifiedName(k); len(e) > 0 {
+				errs = append(errs, fmt.Errorf("role %s: invalid annotation key %q: %s", r, k, strings.Join(e, "; ")))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateMetadataOutputs checks that no extra label is a role label written by the outputs.
+func validateMetadataOutputs(metadata map[string]Metadata, outputs []compiledOutput) error {
+	var errs []error
+	for _, r := range sortedKeys(metadata) {
+		for _, k := range sortedKeys(metadata[r].Labels) {
+			if isOutput(outputs, k) {
+				errs = append(errs, fmt.Errorf("role %s: label %s is written by an output", r, k))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// addMetadata adds the extra labels and annotations of the role to the changes, unless a role
+// earlier in priority order already set the key. It reports whether any is missing on the node.
+func (h *CacheResourceHandler) addMetadata(ch *changes, r desiredRole, labels, annotations map[string]string) bool {
+	md := h.metadata[r.Role]
+	missing := false
+	for _, k := range sortedKeys(md.Labels) {
+		if _, taken := ch.labels[k]; taken {
+			continue
+		}
+		ch.labels[k] = md.Labels[k]
+		if v, ok := labels[k]; !ok || v != md.Labels[k] {
+			missing = true
+		}
+	}
+	for _, k := range sortedKeys(md.Annotations) {
+		if _, taken := ch.annotations[k]; taken {
+			continue
+		}
+		ch.annotations[k] = md.Annotations[k]
+		if v, ok := annotations[k]; !ok || v != md.Annotations[k] {
+			missing = true
+		}
+	}
+	return missing
+}
@@ -0,0 +1,161 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// withOwnedAnnotations records the annotation keys as applied by the field manager in the node's managedFields.
+func withOwnedAnnotations(n *corev1.Node, manager string, keys ...string) *corev1.Node {
+	annotations := map[string]any{}
+	for _, k := range keys {
+		annotations[fieldsKeyPrefix+k] = map[string]any{}
+	}
+	raw, _ := json.Marshal(map[string]any{fieldsMetadata: map[string]any{fieldsAnnotations: annotations}})
+	n.ManagedFields = append(n.ManagedFields, metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	})
+	return n
+}
+
+func TestValidateMetadata(t *testing.T) {
+	valid := map[string]Metadata{"gpu": {
+		Labels:      map[string]string{"workload-class": "accelerated"},
+		Annotations: map[string]string{"example.com/cost-center": "ml platform"},
+	}}
+	if err := ValidateMetadata(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := map[string]Metadata{
+		"label key":      {Labels: map[string]string{"bad key": "a"}},
+		"label value":    {Labels: map[string]string{"workload-class": "not valid"}},
+		"annotation key": {Annotations: map[string]string{"bad key": "a"}},
+	}
+	for name, md := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateMetadata(map[string]Metadata{"gpu": md}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(), WithSources("nodeGroup"),
+		WithMetadata(map[string]Metadata{"gpu": {Labels: map[string]string{rolePrefix + "ingress": ""}}})); err == nil {
+		t.Error("expected error for an extra label written by an output")
+	}
+}
+
+func TestEnsureRole_Metadata(t *testing.T) {
+	metadata := map[string]Metadata{
+		"gpu": {
+			Labels:      map[string]string{"workload-class": "accelerated"},
+			Annotations: map[string]string{"example.com/cost-center": "ml"},
+		},
+		"ingress": {
+			Labels: map[string]string{"workload-class": "edge", "ingress-ready": "true"},
+		},
+	}
+
+	tests := []struct {
+		name            string
+		node            *corev1.Node
+		replace         bool
+		wantLabels      map[string]string
+		wantAnnotations map[string]string
+	}{
+		{
+			name: "first role in priority order wins",
+			node: getTestNode("n1", map[string]string{"nodeGroup": "gpu_ingress"}),
+			wantLabels: map[string]string{
+				rolePrefix + "gpu":     "",
+				rolePrefix + "ingress": "",
+				"workload-class":       "accelerated",
+				"ingress-ready":        "true",
+			},
+			wantAnnotations: map[string]string{"example.com/cost-center": "ml"},
+		},
+		{
+			name: "replace removes metadata of roles no longer resolved",
+			node: withOwnedAnnotations(withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":        "ingress",
+				rolePrefix + "gpu": "",
+				"workload-class":   "accelerated",
+				"stale-extra":      "true",
+			}), fieldManagerDefault, rolePrefix+"gpu", "workload-class", "stale-extra"),
+				fieldManagerDefault, "example.com/cost-center"),
+			replace: true,
+			wantLabels: map[string]string{
+				rolePrefix + "ingress": "",
+				"workload-class":       "edge",
+				"ingress-ready":        "true",
+			},
+		},
+		{
+			name: "no replace keeps owned metadata",
+			node: withOwnedAnnotations(withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":        "ingress",
+				rolePrefix + "gpu": "",
+				"workload-class":   "accelerated",
+			}), fieldManagerDefault, rolePrefix+"gpu", "workload-class"),
+				fieldManagerDefault, "example.com/cost-center"),
+			wantLabels: map[string]string{
+				rolePrefix + "gpu":     "",
+				rolePrefix + "ingress": "",
+				"workload-class":       "edge",
+				"ingress-ready":        "true",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got corev1.Node
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				if err := json.Unmarshal(data, &got); err != nil {
+					t.Fatalf("failed to decode patch: %v", err)
+				}
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithSeparator("_"),
+				WithReplace(tt.replace),
+				WithMetadata(metadata),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), tt.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got.Labels) != len(tt.wantLabels) {
+				t.Errorf("expected labels %v, got %v", tt.wantLabels, got.Labels)
+			}
+			for k, v := range tt.wantLabels {
+				if got.Labels[k] != v {
+					t.Errorf("expected label %s=%s, got %v", k, v, got.Labels)
+				}
+			}
+			if len(got.Annotations) != len(tt.wantAnnotations) {
+				t.Errorf("expected annotations %v, got %v", tt.wantAnnotations, got.Annotations)
+			}
+			for k, v := range tt.wantAnnotations {
+				if got.Annotations[k] != v {
+					t.Errorf("expected annotation %s=%s, got %v", k, v, got.Annotations)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package role
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// roleNameMaxLength is the maximum length of the name segment of a label key.
+	roleNameMaxLength = 63
+	// hashSuffixLength is the length of the hash appended to truncated roles.
+	hashSuffixLength = 8
+)
+
+// Normalization configures how resolved role values are turned into valid role label names
+// before they are validated. The zero value leaves values unchanged.
+type Normalization struct {
+	// Lowercase converts the value to lower case.
+	Lowercase bool
+	// Replacement replaces every character a label name does not allow, and leading or
+	// trailing characters a label name can't start or end with are trimmed.
+	// Empty leaves the characters unchanged.
+	Replacement string
+	// Truncate shortens values longer than a label name allows, appending a hash
+	// of the full value so distinct values stay distinct.
+	Truncate bool
+}
+
+// Validate checks that the replacement only contains characters a label name allows.
+func (n Normalization) Validate() error {
+	for _, r := range n.Replacement {
+		if !isNameChar(r) {
+			return fmt.Errorf("replacement %q must only contain alphanumeric characters, '-', '_' or '.'", n.Replacement)
+		}
+	}
+	return nil
+}
+
+// apply normalizes the role value.
+func (n Normalization) apply(v string) string {
+	if n.Lowercase {
+		v = strings.ToLower(v)
+	}
+
+	if n.Replacement != "" {
+		var b strings.Builder
+		for _, r := range v {
+			if isNameChar(r) {
+				b.WriteRune(r)
+				continue
+			}
+			b.WriteString(n.Replacement)
+		}
+		v = strings.TrimFunc(b.String(), isNotAlphanumeric)
+	}
+
+	if n.Truncate && len(v) > roleNameMaxLength {
+		sum := sha256.Sum256([]byte(v))
+		suffix := hex.EncodeToString(sum[:])[:hashSuffixLength]
+		v = strings.TrimRightFunc(v[:roleNameMaxLength-hashSuffixLength-1], isNotAlphanumeric) + "-" + suffix
+	}
+	return v
+}
+
+func isNameChar(r rune) bool {
+	return r == '-' || r == '_' || r == '.' || !isNotAlphanumeric(r)
+}
+
+func isNotAlphanumeric(r rune) bool {
+	return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9')
+}
@@ -0,0 +1,117 @@
+package role
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestNormalization_Apply(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := []struct {
+		name string
+		n    Normalization
+		in   string
+		want string
+	}{
+		{name: "none", in: "GPU Worker", want: "GPU Worker"},
+		{name: "lowercase", n: Normalization{Lowercase: true}, in: "GPU", want: "gpu"},
+		{name: "replace", n: Normalization{Replacement: "-"}, in: "gpu worker/a100", want: "gpu-worker-a100"},
+		{name: "replace trims", n: Normalization{Replacement: "_"}, in: " _gpu: ", want: "gpu"},
+		{name: "replace multi", n: Normalization{Replacement: "--"}, in: "a b", want: "a--b"},
+		{name: "truncate short", n: Normalization{Truncate: true}, in: "gpu", want: "gpu"},
+		{name: "all", n: Normalization{Lowercase: true, Replacement: "-", Truncate: true}, in: "Team A", want: "team-a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.n.apply(tt.in); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	n := Normalization{Truncate: true}
+	got := n.apply(long)
+	if len(got) != roleNameMaxLength {
+		t.Errorf("expected truncated length %d, got %d (%s)", roleNameMaxLength, len(got), got)
+	}
+	if _, err := renderLabels(mustCompileOutputs(t, DefaultOutputs...), got); err != nil {
+		t.Errorf("expected truncated role to be valid: %v", err)
+	}
+	if other := n.apply(long + "b"); other == got {
+		t.Error("expected distinct values to stay distinct")
+	}
+}
+
+func TestNormalization_Validate(t *testing.T) {
+	if err := (Normalization{Replacement: "-_."}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Normalization{Replacement: "/"}).Validate(); err == nil {
+		t.Error("expected error for invalid replacement")
+	}
+}
+
+func TestEnsureRole_InvalidRole(t *testing.T) {
+	var applied map[string]string
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		applied = getAppliedLabels(t, data)
+		return nil, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("team", "pool"),
+		WithSourceMode(SourceModeAll),
+		WithRecorder(recorder),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	// Label values allow characters label names don't, e.g. a leading underscore
+	node := getTestNode("n1", map[string]string{"team": "_ml", "pool": "gpu"})
+	if err := h.EnsureRole(context.Background(), node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 1 || applied[rolePrefix+"gpu"] != "" {
+		t.Errorf("expected only the valid role to be applied, got %v", applied)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, "Warning "+eventReasonInvalidRole) {
+			t.Errorf("unexpected event: %s", e)
+		}
+	default:
+		t.Error("expected an invalid role event")
+	}
+
+	// With normalization, the value becomes a valid role
+	h, err = NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("team"),
+		WithNormalization(Normalization{Replacement: "-"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	if err := h.EnsureRole(context.Background(), node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := applied[rolePrefix+"ml"]; !ok {
+		t.Errorf("expected normalized role to be applied, got %v", applied)
+	}
+
+	if _, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("team"), WithNormalization(Normalization{Replacement: " "})); err == nil {
+		t.Error("expected error for invalid replacement")
+	}
+}
@@ -0,0 +1,191 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// DefaultOutputs write the node-role.kubernetes.io/<role> label with an empty value.
+var DefaultOutputs = []Output{{Key: rolePrefix + "{{.Role}}"}}
+
+// Output is a label written for every resolved role. Key and Value are Go templates
+// rendered with the role as {{.Role}}, e.g. kubernetes.io/role={{.Role}}
+// or node-role.kubernetes.io/{{.Role}}=true.
+type Output struct {
+	// Key is the template of the label key. It either includes the role verbatim,
+	// writing a label per role, or is constant, writing a single label for the first role.
+	Key string
+	// Value is the template of the label value.
+	Value string
+}
+
+// outputData is the data the output templates are rendered with.
+type outputData struct {
+	Role string
+}
+
+// compiledOutput is an Output with its parsed templates.
+type compiledOutput struct {
+	Output
+	key   *template.Template
+	value *template.Template
+	// perRole is set when the key includes the role, found between prefix and suffix;
+	// otherwise the key is always fixed
+	perRole        bool
+	prefix, suffix string
+	fixed          string
+}
+
+// label is a rendered output label.
+type label struct {
+	key   string
+	value string
+}
+
+// desiredRole is a resolved role with the labels its outputs write.
+type desiredRole struct {
+	Resolution
+	labels []label
+}
+
+// ValidateOutputs parses the output templates, reporting all errors found.
+func ValidateOutputs(outputs ...Output) error {
+	_, err := compileOutputs(outputs)
+	return err
+}
+
+// compileOutputs parses the output templates, reporting all errors found.
+func compileOutputs(outputs []Output) ([]compiledOutput, error) {
+	var errs []error
+	compiled := make([]compiledOutput, 0, len(outputs))
+	keys := map[string]bool{}
+	for _, o := range outputs {
+		co, err := compileOutput(o)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if keys[o.Key] {
+			errs = append(errs, fmt.Errorf("output %s: duplicate key", o.Key))
+			continue
+		}
+		keys[o.Key] = true
+		compiled = append(compiled, co)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return compiled, nil
+}
+
+// Sentinels rendered in place of the role to find where a key template includes it.
+const (
+	sentinelRole  = "a-rolesetter-sentinel-a"
+	sentinelOther = "b-rolesetter-sentinel-b"
+)
+
+func compileOutput(o Output) (compiledOutput, error) {
+	if o.Key == "" {
+		return compiledOutput{}, fmt.Errorf("output key must not be empty")
+	}
+
+	co := compiledOutput{Output: o}
+	var err error
+	if co.key, err = template.New("key").Option("missingkey=error").Parse(o.Key); err != nil {
+		return compiledOutput{}, fmt.Errorf("output %s: invalid key template: %w", o.Key, err)
+	}
+	if co.value, err = template.New("value").Option("missingkey=error").Parse(o.Value); err != nil {
+		return compiledOutput{}, fmt.Errorf("output %s: invalid value template: %w", o.Key, err)
+	}
+
+	key, err := render(co.key, sentinelRole)
+	if err != nil {
+		return compiledOutput{}, fmt.Errorf("output %s: %w", o.Key, err)
+	}
+	other, err := render(co.key, sentinelOther)
+	if err != nil {
+		return compiledOutput{}, fmt.Errorf("output %s: %w", o.Key, err)
+	}
+	if _, err := render(co.value, sentinelRole); err != nil {
+		return compiledOutput{}, fmt.Errorf("output %s: %w", o.Key, err)
+	}
+
+	if key != other {
+		prefix, suffix, found := strings.Cut(key, sentinelRole)
+		if !found || prefix+sentinelOther+suffix != other {
+			return compiledOutput{}, fmt.Errorf("output %s: key template must include the role verbatim", o.Key)
+		}
+		co.perRole, co.prefix, co.suffix = true, prefix, suffix
+	} else {
+		co.fixed = key
+	}
+	return co, nil
+}
+
+// render executes the template for the role.
+func render(t *template.Template, role string) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, outputData{Role: role}); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+// renderLabels renders and validates the label of every output for the role.
+func renderLabels(outputs []compiledOutput, role string) ([]label, error) {
+	res := make([]label, 0, len(outputs))
+	for _, o := range outputs {
+		key, err := render(o.key, role)
+		if err != nil {
+			return nil, err
+		}
+		value, err := render(o.value, role)
+		if err != nil {
+			return nil, err
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid role %q: label key %q: %s", role, key, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid role %q: label value %q for %s: %s", role, value, key, strings.Join(errs, "; "))
+		}
+		res = append(res, label{key: key, value: value})
+	}
+	return res, nil
+}
+
+// match returns the role of a label the output writes, and whether it writes the label.
+func (o compiledOutput) match(key, value string) (string, bool) {
+	if !o.perRole {
+		return value, key == o.fixed
+	}
+	if len(key) > len(o.prefix)+len(o.suffix) && strings.HasPrefix(key, o.prefix) && strings.HasSuffix(key, o.suffix) {
+		return key[len(o.prefix) : len(key)-len(o.suffix)], true
+	}
+	return "", false
+}
+
+// isOutput reports whether any of the outputs writes the label key.
+func isOutput(outputs []compiledOutput, key string) bool {
+	for _, o := range outputs {
+		if _, ok := o.match(key, ""); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// roleOf returns the role a label written by the outputs stands for, or the key itself
+// when no output writes it.
+func roleOf(outputs []compiledOutput, key, value string) string {
+	for _, o := range outputs {
+		if role, ok := o.match(key, value); ok {
+			return role
+		}
+	}
+	return key
+}
@@ -0,0 +1,189 @@
+package role
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func mustCompileOutputs(t *testing.T, outputs ...Output) []compiledOutput {
+	t.Helper()
+	compiled, err := compileOutputs(outputs)
+	if err != nil {
+		t.Fatalf("failed to compile outputs: %v", err)
+	}
+	return compiled
+}
+
+func TestValidateOutputs(t *testing.T) {
+	if err := ValidateOutputs(
+		Output{Key: rolePrefix + "{{.Role}}", Value: "true"},
+		Output{Key: "kubernetes.io/role", Value: "{{.Role}}"},
+		Output{Key: "example.com/{{.Role}}-node"},
+	); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := map[string]Output{
+		"empty key":          {},
+		"invalid template":   {Key: "{{.Role"},
+		"unknown field":      {Key: "{{.Name}}"},
+		"role not verbatim":  {Key: `{{printf "%.3s" .Role}}`},
+		"invalid value tmpl": {Key: "a", Value: "{{end}}"},
+	}
+	for name, o := range tests {
+		if err := ValidateOutputs(o); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if err := ValidateOutputs(Output{Key: "a"}, Output{Key: "a"}); err == nil {
+		t.Error("expected error for duplicate key")
+	}
+}
+
+func TestRenderLabels(t *testing.T) {
+	outputs := mustCompileOutputs(t,
+		Output{Key: rolePrefix + "{{.Role}}", Value: "true"},
+		Output{Key: "kubernetes.io/role", Value: "{{.Role}}"},
+	)
+
+	got, err := renderLabels(outputs, "gpu")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0] != (label{key: rolePrefix + "gpu", value: "true"}) ||
+		got[1] != (label{key: "kubernetes.io/role", value: "gpu"}) {
+		t.Errorf("unexpected labels: %+v", got)
+	}
+
+	for _, r := range []string{"gpu worker", "-gpu", "a/b", strings.Repeat("a", 64)} {
+		if _, err := renderLabels(outputs, r); err == nil {
+			t.Errorf("expected %q to be invalid", r)
+		}
+	}
+}
+
+func TestRoleOf(t *testing.T) {
+	outputs := mustCompileOutputs(t,
+		Output{Key: "example.com/{{.Role}}-node"},
+		Output{Key: "kubernetes.io/role", Value: "{{.Role}}"},
+	)
+	tests := []struct {
+		key, value, want string
+	}{
+		{key: "example.com/gpu-node", want: "gpu"},
+		{key: "kubernetes.io/role", value: "ingress", want: "ingress"},
+		{key: "example.com/-node", want: "example.com/-node"},
+		{key: "other", value: "x", want: "other"},
+	}
+	for _, tt := range tests {
+		if got := roleOf(outputs, tt.key, tt.value); got != tt.want {
+			t.Errorf("roleOf(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEnsureRole_Outputs(t *testing.T) {
+	outputs := []Output{
+		{Key: rolePrefix + "{{.Role}}", Value: "true"},
+		{Key: "kubernetes.io/role", Value: "{{.Role}}"},
+	}
+
+	tests := []struct {
+		name      string
+		node      *corev1.Node
+		replace   bool
+		wantPatch map[string]string
+	}{
+		{
+			name: "all outputs written, fixed key for the first role",
+			node: getTestNode("n1", map[string]string{"nodeGroup": "gpu_ingress"}),
+			wantPatch: map[string]string{
+				rolePrefix + "gpu":     "true",
+				rolePrefix + "ingress": "true",
+				"kubernetes.io/role":   "gpu",
+			},
+		},
+		{
+			name: "in sync",
+			node: withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":          "gpu",
+				rolePrefix + "gpu":   "true",
+				"kubernetes.io/role": "gpu",
+			}), fieldManagerDefault, rolePrefix+"gpu", "kubernetes.io/role"),
+		},
+		{
+			name: "value updated",
+			node: withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":          "gpu",
+				rolePrefix + "gpu":   "",
+				"kubernetes.io/role": "gpu",
+			}), fieldManagerDefault, rolePrefix+"gpu", "kubernetes.io/role"),
+			wantPatch: map[string]string{
+				rolePrefix + "gpu":   "true",
+				"kubernetes.io/role": "gpu",
+			},
+		},
+		{
+			name: "replace reconciles every output",
+			node: withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":            "gpu",
+				rolePrefix + "ingress": "true",
+				"kubernetes.io/role":   "ingress",
+			}), fieldManagerDefault, rolePrefix+"ingress", "kubernetes.io/role"),
+			replace: true,
+			wantPatch: map[string]string{
+				rolePrefix + "gpu":   "true",
+				"kubernetes.io/role": "gpu",
+			},
+		},
+		{
+			name: "no replace keeps owned outputs",
+			node: withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":            "gpu",
+				rolePrefix + "ingress": "true",
+			}), fieldManagerDefault, rolePrefix+"ingress"),
+			wantPatch: map[string]string{
+				rolePrefix + "gpu":     "true",
+				rolePrefix + "ingress": "true",
+				"kubernetes.io/role":   "gpu",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				got = getAppliedLabels(t, data)
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithSeparator("_"),
+				WithOutputs(outputs...),
+				WithReplace(tt.replace),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), tt.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(got) != len(tt.wantPatch) {
+				t.Fatalf("expected patch %v, got %v", tt.wantPatch, got)
+			}
+			for k, v := range tt.wantPatch {
+				if gv, ok := got[k]; !ok || gv != v {
+					t.Errorf("expected %s=%s, got %v", k, v, got)
+				}
+			}
+		})
+	}
+}
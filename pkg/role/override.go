@@ -0,0 +1,163 @@
+package role
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// IgnoreAnnotation excludes a node from reconciliation when set to "true".
+	IgnoreAnnotation = "rolesetter.io/ignore"
+	// RolesAnnotation pins the roles of a node to its comma-separated value,
+	// replacing the roles resolved from the source labels, rules and mappings.
+	RolesAnnotation = "rolesetter.io/roles"
+
+	overrideIgnore = "ignore"
+	overrideRoles  = "roles"
+)
+
+var overrideGauge = metric.NewGauge("node_role_override", "Nodes ignored or with roles pinned by annotation", "node", "override")
+
+// Override is a node excluded from reconciliation or with its roles pinned by annotation.
+type Override struct {
+	Node    string   `json:"node"`
+	Ignored bool     `json:"ignored,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+	// Time is when the override was first observed.
+	Time time.Time `json:"time"`
+}
+
+// OverrideStore holds the override of each node and serves them over HTTP.
+type OverrideStore struct {
+	mu        sync.RWMutex
+	overrides map[string]Override
+}
+
+// NewOverrideStore creates an empty OverrideStore.
+func NewOverrideStore() *OverrideStore {
+	return &OverrideStore{
+		overrides: map[string]Override{},
+	}
+}
+
+// Set records the override for its node, keeping the time of an unchanged one.
+func (s *OverrideStore) Set(o Override) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if prev, ok := s.overrides[o.Node]; ok && prev.Ignored == o.Ignored && slices.Equal(prev.Roles, o.Roles) {
+		return
+	}
+	s.overrides[o.Node] = o
+	if o.Ignored {
+		overrideGauge.Set(1, o.Node, overrideIgnore)
+		overrideGauge.Delete(o.Node, overrideRoles)
+		return
+	}
+	overrideGauge.Set(1, o.Node, overrideRoles)
+	overrideGauge.Delete(o.Node, overrideIgnore)
+}
+
+// Delete removes the override for the node.
+func (s *OverrideStore) Delete(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.overrides[node]; !ok {
+		return
+	}
+	delete(s.overrides, node)
+	overrideGauge.Delete(node, overrideIgnore)
+	overrideGauge.Delete(node, overrideRoles)
+}
+
+// List returns all overrides sorted by node name.
+func (s *OverrideStore) List() []Override {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Override, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		list = append(list, o)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Node < list[j].Node })
+	return list
+}
+
+// ServeHTTP writes the overrides as JSON. A `node` query parameter limits the output to one node.
+func (s *OverrideStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	list := s.List()
+	if node := r.URL.Query().Get("node"); node != "" {
+		filtered := list[:0]
+		for _, o := range list {
+			if o.Node == node {
+				filtered = append(filtered, o)
+			}
+		}
+		list = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// ignored reports whether the node opted out of reconciliation with a trusted ignore annotation.
+func (h *CacheResourceHandler) ignored(n *corev1.Node) bool {
+	v, ok := n.Annotations[IgnoreAnnotation]
+	if !ok || !strings.EqualFold(strings.TrimSpace(v), "true") {
+		return false
+	}
+	if !h.trust.trustedAnnotation(n, IgnoreAnnotation) {
+		h.reportUntrusted(n, IgnoreAnnotation, v)
+		return false
+	}
+	return true
+}
+
+// pinnedRoles returns the roles pinned with a trusted roles annotation, if any. An empty value pins nothing.
+func (h *CacheResourceHandler) pinnedRoles(n *corev1.Node) ([]string, bool) {
+	v, ok := n.Annotations[RolesAnnotation]
+	if !ok {
+		return nil, false
+	}
+	var roles []string
+	for _, r := range strings.Split(v, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			roles = append(roles, r)
+		}
+	}
+	if len(roles) == 0 {
+		return nil, false
+	}
+	if !h.trust.trustedAnnotation(n, RolesAnnotation) {
+		h.reportUntrusted(n, RolesAnnotation, v)
+		return nil, false
+	}
+	return roles, true
+}
+
+// observeOverride records whether the node is ignored or has its roles pinned.
+func (h *CacheResourceHandler) observeOverride(n *corev1.Node, ignored bool, pinned []string) {
+	if !ignored && len(pinned) == 0 {
+		h.overrides.Delete(n.Name)
+		return
+	}
+	h.overrides.Set(Override{
+		Node:    n.Name,
+		Ignored: ignored,
+		Roles:   pinned,
+		Time:    time.Now().UTC(),
+	})
+	if ignored {
+		h.logger.Debug("node ignored by annotation",
+			zap.String("name", n.Name),
+			zap.String("annotation", IgnoreAnnotation),
+		)
+	}
+}
@@ -0,0 +1,213 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// withAnnotations sets the annotations on the node.
+func withAnnotations(n *corev1.Node, annotations map[string]string) *corev1.Node {
+	n.Annotations = annotations
+	return n
+}
+
+func TestOverrideStore_SetListDelete(t *testing.T) {
+	then := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	s := NewOverrideStore()
+	s.Set(Override{Node: "b", Roles: []string{"gpu"}, Time: then})
+	s.Set(Override{Node: "a", Ignored: true, Time: then})
+
+	// An unchanged override keeps the time it was first observed
+	s.Set(Override{Node: "b", Roles: []string{"gpu"}, Time: time.Now()})
+	list := s.List()
+	if len(list) != 2 || list[0].Node != "a" || list[1].Node != "b" {
+		t.Fatalf("unexpected overrides: %+v", list)
+	}
+	if !list[1].Time.Equal(then) {
+		t.Errorf("expected time %v to be kept, got %v", then, list[1].Time)
+	}
+
+	s.Delete("a")
+	s.Delete("missing")
+	if list := s.List(); len(list) != 1 || list[0].Node != "b" {
+		t.Errorf("unexpected overrides after delete: %+v", list)
+	}
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/overrides?node=b", nil))
+	var got []Override
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to decode overrides: %v", err)
+	}
+	if len(got) != 1 || got[0].Roles[0] != "gpu" {
+		t.Errorf("unexpected served overrides: %+v", got)
+	}
+}
+
+func TestEnsureRole_Overrides(t *testing.T) {
+	tests := []struct {
+		name         string
+		node         *corev1.Node
+		trust        Trust
+		wantPatch    bool
+		wantLabels   map[string]string
+		wantOverride *Override
+		wantEvent    string
+	}{
+		{
+			name: "ignored",
+			node: withAnnotations(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				map[string]string{IgnoreAnnotation: "true"}),
+			wantOverride: &Override{Node: "n1", Ignored: true},
+		},
+		{
+			name: "ignore not true",
+			node: withAnnotations(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				map[string]string{IgnoreAnnotation: "false"}),
+			wantPatch:  true,
+			wantLabels: map[string]string{rolePrefix + "gpu": ""},
+		},
+		{
+			name: "pinned roles replace the resolved ones",
+			node: withAnnotations(withOwnedLabels(getTestNode("n1", map[string]string{
+				"nodeGroup":        "gpu",
+				rolePrefix + "old": "",
+			}), fieldManagerDefault, rolePrefix+"old"), map[string]string{RolesAnnotation: "ingress, edge"}),
+			wantPatch:    true,
+			wantLabels:   map[string]string{rolePrefix + "ingress": "", rolePrefix + "edge": ""},
+			wantOverride: &Override{Node: "n1", Roles: []string{"ingress", "edge"}},
+		},
+		{
+			name: "empty pin",
+			node: withAnnotations(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				map[string]string{RolesAnnotation: " , "}),
+			wantPatch:  true,
+			wantLabels: map[string]string{rolePrefix + "gpu": ""},
+		},
+		{
+			name: "untrusted pin",
+			node: withAnnotations(getTestNode("n1", map[string]string{nodeRestrictionPrefix + "pool": "gpu"}),
+				map[string]string{RolesAnnotation: "ingress"}),
+			trust:      Trust{Enabled: true, Managers: []string{"kubectl-annotate"}},
+			wantPatch:  true,
+			wantLabels: map[string]string{rolePrefix + "gpu": ""},
+			wantEvent:  eventReasonUntrustedSource,
+		},
+		{
+			name: "pin without trusted managers",
+			node: withOwnedAnnotations(withAnnotations(getTestNode("n1", map[string]string{nodeRestrictionPrefix + "pool": "gpu"}),
+				map[string]string{RolesAnnotation: "ingress"}), "kubectl-annotate", RolesAnnotation),
+			trust:      Trust{Enabled: true},
+			wantPatch:  true,
+			wantLabels: map[string]string{rolePrefix + "gpu": ""},
+			wantEvent:  eventReasonUntrustedSource,
+		},
+		{
+			name: "trusted pin",
+			node: withOwnedAnnotations(withAnnotations(getTestNode("n1", map[string]string{nodeRestrictionPrefix + "pool": "gpu"}),
+				map[string]string{RolesAnnotation: "ingress"}), "kubectl-annotate", RolesAnnotation),
+			trust:        Trust{Enabled: true, Managers: []string{"kubectl-annotate"}},
+			wantPatch:    true,
+			wantLabels:   map[string]string{rolePrefix + "ingress": ""},
+			wantOverride: &Override{Node: "n1", Roles: []string{"ingress"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *corev1.Node
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				got = &corev1.Node{}
+				if err := json.Unmarshal(data, got); err != nil {
+					t.Fatalf("failed to decode patch: %v", err)
+				}
+				return nil, nil
+			}
+			recorder := record.NewFakeRecorder(10)
+			overrides := NewOverrideStore()
+			sources := "nodeGroup"
+			if tt.trust.Enabled {
+				sources = nodeRestrictionPrefix + "pool"
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources(sources),
+				WithTrust(tt.trust),
+				WithOverrideStore(overrides),
+				WithRecorder(recorder),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), tt.node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !tt.wantPatch {
+				if got != nil {
+					t.Errorf("expected no patch, got %+v", got)
+				}
+			} else {
+				if got == nil {
+					t.Fatal("expected a patch")
+				}
+				if len(got.Labels) != len(tt.wantLabels) {
+					t.Errorf("expected labels %v, got %v", tt.wantLabels, got.Labels)
+				}
+				for k, v := range tt.wantLabels {
+					if l, ok := got.Labels[k]; !ok || l != v {
+						t.Errorf("expected label %s=%s, got %v", k, v, got.Labels)
+					}
+				}
+			}
+
+			list := overrides.List()
+			if tt.wantOverride == nil {
+				if len(list) != 0 {
+					t.Errorf("expected no override, got %+v", list)
+				}
+			} else if len(list) != 1 || list[0].Ignored != tt.wantOverride.Ignored ||
+				strings.Join(list[0].Roles, ",") != strings.Join(tt.wantOverride.Roles, ",") {
+				t.Errorf("expected override %+v, got %+v", tt.wantOverride, list)
+			}
+
+			if tt.wantEvent != "" {
+				select {
+				case e := <-recorder.Events:
+					if !strings.Contains(e, tt.wantEvent) {
+						t.Errorf("expected %s event, got %q", tt.wantEvent, e)
+					}
+				default:
+					t.Errorf("expected %s event", tt.wantEvent)
+				}
+			}
+		})
+	}
+}
+
+func TestRelevant_Overrides(t *testing.T) {
+	h, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(), WithSources("nodeGroup"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	for _, key := range []string{IgnoreAnnotation, RolesAnnotation} {
+		oldNode := getTestNode("n1", nil)
+		newNode := withAnnotations(getTestNode("n1", nil), map[string]string{key: "true"})
+		if !h.Relevant(oldNode, newNode) {
+			t.Errorf("expected a change of %s to be relevant", key)
+		}
+	}
+	if h.Relevant(getTestNode("n1", nil), withAnnotations(getTestNode("n1", nil), map[string]string{"other": "a"})) {
+		t.Error("expected other annotation changes to be irrelevant without rules")
+	}
+}
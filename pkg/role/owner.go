@@ -0,0 +1,102 @@
+package role
+
+import (
+	"encoding/json"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// fieldManagerDefault is the server-side apply field manager used when none is configured.
+	fieldManagerDefault = "rolesetter"
+
+	fieldsMetadata    = "f:metadata"
+	fieldsLabels      = "f:labels"
+	fieldsAnnotations = "f:annotations"
+	fieldsKeyPrefix   = "f:"
+)
+
+// ownedFields are the metadata keys owned by a field manager on a node.
+type ownedFields struct {
+	labels      map[string]bool
+	annotations map[string]bool
+}
+
+// getOwnedFields returns the label and annotation keys the field manager owns
+// on the node through server-side apply, based on the node's managedFields.
+// Labels and annotations written by other managers (kubelet, kubeadm, humans) are never included.
+func getOwnedFields(n *corev1.Node, manager string) ownedFields {
+	owned := ownedFields{
+		labels:      map[string]bool{},
+		annotations: map[string]bool{},
+	}
+
+	for _, mf := range n.ManagedFields {
+		if mf.Manager != manager || mf.Operation != metav1.ManagedFieldsOperationApply || mf.Subresource != "" {
+			continue
+		}
+		if mf.FieldsV1 == nil {
+			continue
+		}
+
+		meta := decodeFields(mf.FieldsV1.Raw)[fieldsMetadata]
+		fields := decodeFields(meta)
+		collectFieldKeys(fields[fieldsLabels], owned.labels)
+		collectFieldKeys(fields[fieldsAnnotations], owned.annotations)
+	}
+
+	return owned
+}
+
+// decodeFields decodes one level of a FieldsV1 set, returning nil if it is malformed.
+func decodeFields(raw json.RawMessage) map[string]json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+func collectFieldKeys(raw json.RawMessage, into map[string]bool) {
+	for k := range decodeFields(raw) {
+		if strings.HasPrefix(k, fieldsKeyPrefix) {
+			into[strings.TrimPrefix(k, fieldsKeyPrefix)] = true
+		}
+	}
+}
+
+// ownedMetadata returns the extra labels and annotations, set for any role, the field manager owns on the node.
+// Like role labels of removed outputs, owned keys no role sets anymore are left out, so the apply drops them.
+func ownedMetadata(n *corev1.Node, manager string, metadata map[string]Metadata) ownedFields {
+	owned := getOwnedFields(n, manager)
+	res := ownedFields{labels: map[string]bool{}, annotations: map[string]bool{}}
+	for _, md := range metadata {
+		for k := range md.Labels {
+			if owned.labels[k] {
+				res.labels[k] = true
+			}
+		}
+		for k := range md.Annotations {
+			if owned.annotations[k] {
+				res.annotations[k] = true
+			}
+		}
+	}
+	return res
+}
+
+// ownedRoles returns the role label keys, written by any of the outputs, the field manager owns on the node.
+func ownedRoles(n *corev1.Node, manager string, outputs []compiledOutput) map[string]bool {
+	roles := map[string]bool{}
+	for k := range getOwnedFields(n, manager).labels {
+		if isOutput(outputs, k) {
+			roles[k] = true
+		}
+	}
+	return roles
+}
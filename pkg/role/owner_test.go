@@ -0,0 +1,147 @@
+package role
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestGetOwnedFields(t *testing.T) {
+	n := getTestNode("n1", nil)
+	n.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:   fieldManagerDefault,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{".":{},"f:node-role.kubernetes.io/worker":{},"f:team":{}},` +
+				`"f:annotations":{"f:rolesetter.io/x":{}}},"f:spec":{"f:taints":{}}}`)},
+		},
+		{
+			// Same manager through a non-apply operation is not ownership via apply
+			Manager:   fieldManagerDefault,
+			Operation: metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:node-role.kubernetes.io/legacy":{}}}}`)},
+		},
+		{
+			Manager:   "kubeadm",
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:node-role.kubernetes.io/control-plane":{}}}}`)},
+		},
+		{
+			Manager:   fieldManagerDefault,
+			Operation: metav1.ManagedFieldsOperationApply,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(`not json`)},
+		},
+	}
+
+	owned := getOwnedFields(n, fieldManagerDefault)
+	if len(owned.labels) != 2 || !owned.labels[rolePrefix+"worker"] || !owned.labels["team"] {
+		t.Errorf("unexpected owned labels: %v", owned.labels)
+	}
+	if len(owned.annotations) != 1 || !owned.annotations["rolesetter.io/x"] {
+		t.Errorf("unexpected owned annotations: %v", owned.annotations)
+	}
+
+	outputs := mustCompileOutputs(t, DefaultOutputs...)
+	roles := ownedRoles(n, fieldManagerDefault, outputs)
+	if len(roles) != 1 || !roles[rolePrefix+"worker"] {
+		t.Errorf("unexpected owned roles: %v", roles)
+	}
+	if got := ownedRoles(getTestNode("n2", nil), fieldManagerDefault, outputs); len(got) != 0 {
+		t.Errorf("expected no owned roles, got %v", got)
+	}
+}
+
+func TestEnsureRole_GC(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		owned      []string
+		gc         bool
+		wantPatch  bool
+		wantLabels []string
+	}{
+		{
+			name:       "add takes ownership",
+			labels:     map[string]string{"test-label": "worker"},
+			gc:         true,
+			wantPatch:  true,
+			wantLabels: []string{rolePrefix + "worker"},
+		},
+		{
+			name:      "source removed, owned role dropped",
+			labels:    map[string]string{rolePrefix + "worker": ""},
+			owned:     []string{rolePrefix + "worker"},
+			gc:        true,
+			wantPatch: true,
+		},
+		{
+			name:      "source removed, unowned role kept",
+			labels:    map[string]string{rolePrefix + "control-plane": ""},
+			gc:        true,
+			wantPatch: false,
+		},
+		{
+			name:      "source removed, gc disabled",
+			labels:    map[string]string{rolePrefix + "worker": ""},
+			owned:     []string{rolePrefix + "worker"},
+			gc:        false,
+			wantPatch: false,
+		},
+		{
+			name:       "source changed, owned role swapped",
+			labels:     map[string]string{"test-label": "builder", rolePrefix + "worker": "", rolePrefix + "infra": ""},
+			owned:      []string{rolePrefix + "worker"},
+			gc:         true,
+			wantPatch:  true,
+			wantLabels: []string{rolePrefix + "builder"},
+		},
+		{
+			name:       "existing unowned role is claimed",
+			labels:     map[string]string{"test-label": "worker", rolePrefix + "worker": ""},
+			gc:         true,
+			wantPatch:  true,
+			wantLabels: []string{rolePrefix + "worker"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPatchData []byte
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				gotPatchData = data
+				return nil, nil
+			}
+			node := withOwnedLabels(getTestNode("n1", tt.labels), fieldManagerDefault, tt.owned...)
+
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(), WithSources("test-label"), WithGC(tt.gc))
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			h.EnsureRole(context.Background(), node)
+
+			if !tt.wantPatch {
+				if gotPatchData != nil {
+					t.Fatalf("expected no patch, got %s", gotPatchData)
+				}
+				return
+			}
+			if gotPatchData == nil {
+				t.Fatal("expected patch, got none")
+			}
+
+			labels := getAppliedLabels(t, gotPatchData)
+			if len(labels) != len(tt.wantLabels) {
+				t.Fatalf("expected labels %v, got %v", tt.wantLabels, labels)
+			}
+			for _, k := range tt.wantLabels {
+				if _, ok := labels[k]; !ok {
+					t.Errorf("expected %s to be applied, got %v", k, labels)
+				}
+			}
+		})
+	}
+}
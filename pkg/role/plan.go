@@ -0,0 +1,90 @@
+package role
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+)
+
+const (
+	planOperationAdd    = "add"
+	planOperationRemove = "remove"
+)
+
+var plannedGauge = metric.NewGauge("node_role_planned_changes", "Number of role label changes planned for a node in dry-run mode", "node", "operation")
+
+// Plan is the set of role label changes computed for a node in dry-run mode.
+type Plan struct {
+	Node   string    `json:"node"`
+	Add    []string  `json:"add,omitempty"`
+	Remove []string  `json:"remove,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// PlanStore holds the latest Plan for each node and serves them over HTTP.
+type PlanStore struct {
+	mu    sync.RWMutex
+	plans map[string]Plan
+}
+
+// NewPlanStore creates an empty PlanStore.
+func NewPlanStore() *PlanStore {
+	return &PlanStore{
+		plans: map[string]Plan{},
+	}
+}
+
+// Set records the plan for its node, replacing any previous one.
+func (s *PlanStore) Set(p Plan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plans[p.Node] = p
+	plannedGauge.Set(float64(len(p.Add)), p.Node, planOperationAdd)
+	plannedGauge.Set(float64(len(p.Remove)), p.Node, planOperationRemove)
+}
+
+// Delete removes the plan for the node.
+func (s *PlanStore) Delete(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.plans[node]; !ok {
+		return
+	}
+	delete(s.plans, node)
+	plannedGauge.Delete(node, planOperationAdd)
+	plannedGauge.Delete(node, planOperationRemove)
+}
+
+// List returns all plans sorted by node name.
+func (s *PlanStore) List() []Plan {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Plan, 0, len(s.plans))
+	for _, p := range s.plans {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Node < list[j].Node })
+	return list
+}
+
+// ServeHTTP writes the plans as JSON. A `node` query parameter limits the output to one node.
+func (s *PlanStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	list := s.List()
+	if node := r.URL.Query().Get("node"); node != "" {
+		filtered := list[:0]
+		for _, p := range list {
+			if p.Node == node {
+				filtered = append(filtered, p)
+			}
+		}
+		list = filtered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,117 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPlanStore_SetListDelete(t *testing.T) {
+	s := NewPlanStore()
+	s.Set(Plan{Node: "b", Add: []string{rolePrefix + "gpu"}})
+	s.Set(Plan{Node: "a", Remove: []string{rolePrefix + "old"}})
+
+	list := s.List()
+	if len(list) != 2 || list[0].Node != "a" || list[1].Node != "b" {
+		t.Fatalf("unexpected plans: %+v", list)
+	}
+
+	s.Delete("a")
+	s.Delete("missing")
+	if list := s.List(); len(list) != 1 || list[0].Node != "b" {
+		t.Errorf("unexpected plans after delete: %+v", list)
+	}
+}
+
+func TestPlanStore_ServeHTTP(t *testing.T) {
+	s := NewPlanStore()
+	s.Set(Plan{Node: "a", Add: []string{rolePrefix + "gpu"}})
+	s.Set(Plan{Node: "b", Add: []string{rolePrefix + "ingress"}})
+
+	tests := []struct {
+		url  string
+		want int
+	}{
+		{url: "/plan", want: 2},
+		{url: "/plan?node=b", want: 1},
+		{url: "/plan?node=c", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d", rec.Code)
+			}
+			var got []Plan
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode plans: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("expected %d plans, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnsureRole_DryRun(t *testing.T) {
+	var gotOpts metav1.PatchOptions
+	calls := 0
+	patcher := func(_ context.Context, _ string, _ types.PatchType, _ []byte, opts metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		calls++
+		gotOpts = opts
+		return nil, nil
+	}
+	plans := NewPlanStore()
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("test-label"),
+		WithReplace(true),
+		WithDryRun(true),
+		WithPlanStore(plans),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"test-label":       "worker",
+		rolePrefix + "old": "",
+	}), fieldManagerDefault, rolePrefix+"old")
+	h.EnsureRole(context.Background(), node)
+
+	if calls != 1 {
+		t.Fatalf("expected one dry-run apply, got %d", calls)
+	}
+	if len(gotOpts.DryRun) != 1 || gotOpts.DryRun[0] != metav1.DryRunAll {
+		t.Errorf("expected dry-run apply, got %v", gotOpts.DryRun)
+	}
+
+	list := plans.List()
+	if len(list) != 1 {
+		t.Fatalf("expected one plan, got %+v", list)
+	}
+	p := list[0]
+	if len(p.Add) != 1 || p.Add[0] != rolePrefix+"worker" {
+		t.Errorf("unexpected planned additions: %v", p.Add)
+	}
+	if len(p.Remove) != 1 || p.Remove[0] != rolePrefix+"old" {
+		t.Errorf("unexpected planned removals: %v", p.Remove)
+	}
+
+	// Once the node no longer needs changes, its plan is cleared
+	done := withOwnedLabels(getTestNode("n1", map[string]string{
+		"test-label":          "worker",
+		rolePrefix + "worker": "",
+	}), fieldManagerDefault, rolePrefix+"worker")
+	h.EnsureRole(context.Background(), done)
+	if list := plans.List(); len(list) != 0 {
+		t.Errorf("expected plan to be cleared, got %+v", list)
+	}
+}
@@ -0,0 +1,107 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// eventReasonProtectedRole is the reason of the Node event recorded for a rejected protected role
+	eventReasonProtectedRole = "ProtectedRole"
+
+	operationAdd    = "add"
+	operationRemove = "remove"
+)
+
+// DefaultProtectedRoles are the roles denied when no deny list is set.
+var DefaultProtectedRoles = []string{"control-plane", "master"}
+
+var protectedCounter = metric.NewCounter("node_role_protected_rejected_total",
+	"Total number of role changes rejected because the role is protected", "role", "source", "operation")
+
+// Protection limits the roles the handler may add or remove. Patterns are regular
+// expressions matching the whole role. A role is protected when it matches a deny
+// pattern, or when allow patterns are set and it matches none of them.
+type Protection struct {
+	// Allow lists the only roles that may be managed; empty allows every role not denied.
+	Allow []string
+	// Deny lists the roles that are never managed; nil denies DefaultProtectedRoles.
+	Deny []string
+}
+
+// compiledProtection is a Protection with its compiled patterns.
+type compiledProtection struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// Validate compiles the patterns, reporting all errors found.
+func (p Protection) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+func (p Protection) compile() (compiledProtection, error) {
+	deny := p.Deny
+	if deny == nil {
+		deny = DefaultProtectedRoles
+	}
+
+	var errs []error
+	var cp compiledProtection
+	cp.allow, errs = compilePatterns("allow", p.Allow, errs)
+	cp.deny, errs = compilePatterns("deny", deny, errs)
+	if len(errs) > 0 {
+		return compiledProtection{}, errors.Join(errs...)
+	}
+	return cp, nil
+}
+
+func compilePatterns(list string, patterns []string, errs []error) ([]*regexp.Regexp, []error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile("^(?:" + p + ")$")
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s pattern %q: %w", list, p, err))
+			continue
+		}
+		res = append(res, re)
+	}
+	return res, errs
+}
+
+// protected reports whether the role may not be added or removed.
+func (p compiledProtection) protected(role string) bool {
+	for _, re := range p.deny {
+		if re.MatchString(role) {
+			return true
+		}
+	}
+	if len(p.allow) == 0 {
+		return false
+	}
+	for _, re := range p.allow {
+		if re.MatchString(role) {
+			return false
+		}
+	}
+	return true
+}
+
+// reportProtected records the security log, metric and Node event for a rejected change of a protected role.
+func (h *CacheResourceHandler) reportProtected(n *corev1.Node, role, source, operation string) {
+	protectedCounter.Increment(role, source, operation)
+	h.logger.Named("security").Warn("rejected change of protected role",
+		zap.String("node", n.Name),
+		zap.String("role", role),
+		zap.String("source", source),
+		zap.String("operation", operation),
+	)
+	h.eventf(n, corev1.EventTypeWarning, eventReasonProtectedRole,
+		"Rejected %s of protected role %q from %s", operation, role, source)
+}
@@ -0,0 +1,110 @@
+package role
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestProtection_Protected(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Protection
+		role string
+		want bool
+	}{
+		{name: "default control-plane", role: "control-plane", want: true},
+		{name: "default master", role: "master", want: true},
+		{name: "default worker", role: "worker", want: false},
+		{name: "default whole match", role: "control-plane-gpu", want: false},
+		{name: "empty deny", p: Protection{Deny: []string{}}, role: "master", want: false},
+		{name: "deny regex", p: Protection{Deny: []string{"infra-.*"}}, role: "infra-etcd", want: true},
+		{name: "deny replaces defaults", p: Protection{Deny: []string{"infra-.*"}}, role: "master", want: false},
+		{name: "allowed", p: Protection{Allow: []string{"gpu|cpu"}}, role: "gpu", want: false},
+		{name: "not allowed", p: Protection{Allow: []string{"gpu|cpu"}}, role: "ingress", want: true},
+		{name: "deny wins over allow", p: Protection{Allow: []string{".*"}}, role: "master", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp, err := tt.p.compile()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := cp.protected(tt.role); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProtection_Validate(t *testing.T) {
+	if err := (Protection{Allow: []string{"gpu-.*"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := (Protection{Allow: []string{"("}, Deny: []string{"["}}).Validate()
+	if err == nil {
+		t.Fatal("expected error for invalid patterns")
+	}
+	if !strings.Contains(err.Error(), "allow") || !strings.Contains(err.Error(), "deny") {
+		t.Errorf("expected errors for both lists, got %v", err)
+	}
+}
+
+func TestEnsureRole_ProtectedRole(t *testing.T) {
+	var applied map[string]string
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		applied = getAppliedLabels(t, data)
+		return nil, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("nodeGroup"),
+		WithSeparator("_"),
+		WithReplace(true),
+		WithRecorder(recorder),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	// A protected role is never added, and an owned one is never removed
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"nodeGroup":           "control-plane_gpu",
+		rolePrefix + "master": "",
+	}), fieldManagerDefault, rolePrefix+"master")
+	if err := h.EnsureRole(context.Background(), node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 2 {
+		t.Fatalf("expected gpu and master to be applied, got %v", applied)
+	}
+	for _, k := range []string{rolePrefix + "gpu", rolePrefix + "master"} {
+		if _, ok := applied[k]; !ok {
+			t.Errorf("expected %s to be applied, got %v", k, applied)
+		}
+	}
+
+	for _, op := range []string{operationAdd, operationRemove} {
+		select {
+		case e := <-recorder.Events:
+			if !strings.Contains(e, "Warning "+eventReasonProtectedRole) || !strings.Contains(e, op) {
+				t.Errorf("unexpected event: %s", e)
+			}
+		default:
+			t.Errorf("expected a protected role %s event", op)
+		}
+	}
+
+	if _, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("nodeGroup"), WithProtection(Protection{Deny: []string{"("}})); err == nil {
+		t.Error("expected error for invalid protected role pattern")
+	}
+}
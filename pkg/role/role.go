@@ -3,22 +3,37 @@ package role
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
-	backoff "github.com/cenkalti/backoff/v4"
 	"github.com/mchmarny/rolesetter/pkg/metric"
 	"go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	rolePrefix   = "node-role.kubernetes.io/"
 	patchTimeout = 15 * time.Second
+
+	// failure reasons reported in the failure metric
+	reasonInvalidRole = "invalid_role"
+	reasonConflict    = "conflict"
+	reasonAPIError    = "api_error"
+
+	// reasons of the Node events recorded for role changes and failures
+	eventReasonRoleApplied = "RoleApplied"
+	eventReasonRoleRemoved = "RoleRemoved"
+	eventReasonPatchFailed = "PatchFailed"
+	eventReasonInvalidRole = "InvalidRole"
 )
 
 // NodePatcher defines the function signature for patching a Node.
@@ -32,157 +47,928 @@ type NodePatcher func(
 
 // CacheResourceHandler handles Node events and ensures the correct role label is applied.
 type CacheResourceHandler struct {
-	patcher   NodePatcher
-	logger    *zap.Logger
-	roleLabel string
-	replace   bool
+	patcher NodePatcher
+	logger  *zap.Logger
+	sources []string
+	mode    SourceMode
+	sep     string
+	rules   []Rule
+	replace bool
+	gc      bool
+
+	transforms  []Transform
+	defaultRole string
+	normalize   Normalization
+	outputs     []Output
+	protection  Protection
+	trust       Trust
+	taints      map[string][]Taint
+	startup     StartupTaint
+	metadata    map[string]Metadata
+	status      Status
+	generation  int64
+	recorder    record.EventRecorder
+
+	fieldManager string
+	force        bool
+	dryRun       bool
+	plans        *PlanStore
+	overrides    *OverrideStore
+
+	mappings        []Mapping
+	mappingStore    *MappingStore
+	mappingsPending bool
+
+	compiled           []compiledRule
+	compiledMappings   []compiledMapping
+	compiledTransforms []compiledTransform
+	compiledOutputs    []compiledOutput
+	compiledProtection compiledProtection
+}
+
+// Option is a functional option for configuring CacheResourceHandler.
+type Option func(*CacheResourceHandler)
+
+// WithSources sets the priority-ordered list of source label keys.
+func WithSources(sources ...string) Option {
+	return func(h *CacheResourceHandler) {
+		h.sources = sources
+	}
+}
+
+// WithSourceMode sets how multiple source labels are resolved.
+func WithSourceMode(mode SourceMode) Option {
+	return func(h *CacheResourceHandler) {
+		h.mode = mode
+	}
+}
+
+// WithSeparator sets the separator splitting a source label value into several roles.
+func WithSeparator(sep string) Option {
+	return func(h *CacheResourceHandler) {
+		h.sep = sep
+	}
+}
+
+// WithRules sets the CEL rules evaluated against the Node in addition to the source labels.
+func WithRules(rules ...Rule) Option {
+	return func(h *CacheResourceHandler) {
+		h.rules = rules
+	}
+}
+
+// WithReplace sets whether the role labels owned by the handler are reconciled to the full set
+// resolved for the node, removing the ones no longer resolved.
+func WithReplace(replace bool) Option {
+	return func(h *CacheResourceHandler) {
+		h.replace = replace
+	}
+}
+
+// WithGC sets whether role labels previously applied by the handler are removed
+// once the source that produced them is gone.
+func WithGC(gc bool) Option {
+	return func(h *CacheResourceHandler) {
+		h.gc = gc
+	}
+}
+
+// WithTransforms sets the chain that turns source label values into roles.
+func WithTransforms(transforms ...Transform) Option {
+	return func(h *CacheResourceHandler) {
+		h.transforms = transforms
+	}
+}
+
+// WithDefaultRole sets the role used when the transforms turn a source label value empty.
+func WithDefaultRole(role string) Option {
+	return func(h *CacheResourceHandler) {
+		h.defaultRole = role
+	}
+}
+
+// WithNormalization sets how resolved role values are normalized before they are validated.
+func WithNormalization(n Normalization) Option {
+	return func(h *CacheResourceHandler) {
+		h.normalize = n
+	}
+}
+
+// WithOutputs sets the labels written for every resolved role, DefaultOutputs when empty.
+func WithOutputs(outputs ...Output) Option {
+	return func(h *CacheResourceHandler) {
+		h.outputs = outputs
+	}
+}
+
+// WithProtection sets the roles the handler must never add or remove.
+func WithProtection(p Protection) Option {
+	return func(h *CacheResourceHandler) {
+		h.protection = p
+	}
+}
+
+// WithTrust sets which source labels are trusted to drive roles.
+func WithTrust(t Trust) Option {
+	return func(h *CacheResourceHandler) {
+		h.trust = t
+	}
+}
+
+// WithTaints sets the taints applied to the nodes with each role.
+func WithTaints(taints map[string][]Taint) Option {
+	return func(h *CacheResourceHandler) {
+		h.taints = taints
+	}
+}
+
+// WithStartupTaint sets the taint removed from nodes once their roles are applied.
+func WithStartupTaint(s StartupTaint) Option {
+	return func(h *CacheResourceHandler) {
+		h.startup = s
+	}
+}
+
+// WithMetadata sets the extra labels and annotations written to the nodes with each role.
+func WithMetadata(metadata map[string]Metadata) Option {
+	return func(h *CacheResourceHandler) {
+		h.metadata = metadata
+	}
+}
+
+// WithStatus sets whether the outcome of each reconcile is recorded in the status annotation of the node.
+func WithStatus(s Status) Option {
+	return func(h *CacheResourceHandler) {
+		h.status = s
+	}
+}
+
+// WithGeneration sets the config generation recorded in the status annotation.
+func WithGeneration(generation int64) Option {
+	return func(h *CacheResourceHandler) {
+		h.generation = generation
+	}
+}
+
+// WithRecorder sets the recorder of the events reported on Nodes.
+func WithRecorder(recorder record.EventRecorder) Option {
+	return func(h *CacheResourceHandler) {
+		h.recorder = recorder
+	}
+}
+
+// WithFieldManager sets the server-side apply field manager used to track the labels the handler owns.
+func WithFieldManager(manager string) Option {
+	return func(h *CacheResourceHandler) {
+		h.fieldManager = manager
+	}
+}
+
+// WithForce sets whether ownership of conflicting labels is forcibly taken from other field managers.
+func WithForce(force bool) Option {
+	return func(h *CacheResourceHandler) {
+		h.force = force
+	}
+}
+
+// WithDryRun sets whether changes are only planned (and validated by the API server
+// with a dry-run apply) instead of being persisted.
+func WithDryRun(dryRun bool) Option {
+	return func(h *CacheResourceHandler) {
+		h.dryRun = dryRun
+	}
+}
+
+// WithPlanStore sets the store where dry-run plans are recorded.
+func WithPlanStore(plans *PlanStore) Option {
+	return func(h *CacheResourceHandler) {
+		h.plans = plans
+	}
+}
+
+// WithOverrideStore sets the store where the nodes ignored or with roles pinned by annotation are recorded.
+func WithOverrideStore(overrides *OverrideStore) Option {
+	return func(h *CacheResourceHandler) {
+		h.overrides = overrides
+	}
+}
+
+// WithMappings sets the mappings evaluated after the source labels and rules.
+func WithMappings(mappings ...Mapping) Option {
+	return func(h *CacheResourceHandler) {
+		h.mappings = mappings
+	}
+}
+
+// WithMappingStore sets the store where the nodes matched by each mapping and its conflicts are recorded.
+func WithMappingStore(store *MappingStore) Option {
+	return func(h *CacheResourceHandler) {
+		h.mappingStore = store
+	}
+}
+
+// WithMappingsPending sets whether the mappings are still loading, in which case no owned
+// role label is removed, so GC and replace never act on a partial rule set.
+func WithMappingsPending(pending bool) Option {
+	return func(h *CacheResourceHandler) {
+		h.mappingsPending = pending
+	}
 }
 
 // NewCacheResourceHandler creates a validated CacheResourceHandler.
-func NewCacheResourceHandler(patcher NodePatcher, logger *zap.Logger, roleLabel string, replace bool) (*CacheResourceHandler, error) {
-	if patcher == nil {
+func NewCacheResourceHandler(patcher NodePatcher, logger *zap.Logger, opts ...Option) (*CacheResourceHandler, error) {
+	h := &CacheResourceHandler{
+		patcher: patcher,
+		logger:  logger,
+		mode:    SourceModeFirst,
+
+		fieldManager: fieldManagerDefault,
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.patcher == nil {
 		return nil, fmt.Errorf("patcher must not be nil")
 	}
-	if logger == nil {
+	if h.logger == nil {
 		return nil, fmt.Errorf("logger must not be nil")
 	}
-	if roleLabel == "" {
-		return nil, fmt.Errorf("role label must not be empty")
+	if h.fieldManager == "" {
+		return nil, fmt.Errorf("field manager must not be empty")
+	}
+	// With a mapping store, mappings are managed at runtime and may all be added later
+	if len(h.sources) == 0 && len(h.rules) == 0 && len(h.mappings) == 0 && h.mappingStore == nil {
+		return nil, fmt.Errorf("at least one source label, rule or mapping must be specified")
+	}
+	for _, s := range h.sources {
+		if s == "" {
+			return nil, fmt.Errorf("source label must not be empty")
+		}
+	}
+	if _, err := ParseSourceMode(string(h.mode)); err != nil {
+		return nil, err
+	}
+	if err := ValidateSeparator(h.sep); err != nil {
+		return nil, err
+	}
+	if err := h.normalize.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid normalization: %w", err)
+	}
+	if err := h.trust.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid trust: %w", err)
+	}
+	if err := ValidateTaints(h.taints); err != nil {
+		return nil, fmt.Errorf("invalid taints: %w", err)
+	}
+	if err := h.startup.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid startup taint: %w", err)
+	}
+	if err := h.status.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid status: %w", err)
+	}
+
+	if h.plans == nil {
+		h.plans = NewPlanStore()
+	}
+	if h.overrides == nil {
+		h.overrides = NewOverrideStore()
+	}
+	if h.mappingStore == nil {
+		h.mappingStore = NewMappingStore()
+	}
+
+	compiled, err := compileRules(h.rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	h.compiled = compiled
+
+	compiledTransforms, err := compileTransforms(h.transforms)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transforms: %w", err)
 	}
-	return &CacheResourceHandler{
-		patcher:   patcher,
-		logger:    logger,
-		roleLabel: roleLabel,
-		replace:   replace,
-	}, nil
+	h.compiledTransforms = compiledTransforms
+
+	if len(h.outputs) == 0 {
+		h.outputs = DefaultOutputs
+	}
+	compiledOutputs, err := compileOutputs(h.outputs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid outputs: %w", err)
+	}
+	h.compiledOutputs = compiledOutputs
+
+	if err := ValidateMetadata(h.metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	if err := validateMetadataOutputs(h.metadata, h.compiledOutputs); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	compiledProtection, err := h.protection.compile()
+	if err != nil {
+		return nil, fmt.Errorf("invalid protected roles: %w", err)
+	}
+	h.compiledProtection = compiledProtection
+
+	compiledMappings, err := compileMappings(h.mappings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mappings: %w", err)
+	}
+	h.compiledMappings = compiledMappings
+
+	return h, nil
 }
 
 var (
-	successCounter = metric.NewCounter("node_role_patch_success_total", "Total number of successful node role patches", "role")
-	failureCounter = metric.NewCounter("node_role_patch_failure_total", "Total number of failed node role patches", "role")
+	successCounter  = metric.NewCounter("node_role_patch_success_total", "Total number of successful node role patches", "role", "source")
+	failureCounter  = metric.NewCounter("node_role_patch_failure_total", "Total number of failed node role patches", "role", "source", "reason")
+	removedCounter  = metric.NewCounter("node_role_removed_total", "Total number of node role labels removed", "role")
+	conflictCounter = metric.NewCounter("node_role_patch_conflict_total", "Total number of role label conflicts with other field managers", "role")
 )
 
-// EnsureRole checks if the Node has the correct role label and patches it if necessary.
-func (h *CacheResourceHandler) EnsureRole(ctx context.Context, obj interface{}) {
+// EnsureRole checks if the Node has the correct role labels and taints and applies them if necessary.
+// Taints are patched once the labels are applied, so the startup taint is only removed
+// when the node resolves roles, none was rejected, and all of them are on the node.
+// The outcome is recorded in the status annotation of the node when enabled.
+// Nodes with the ignore annotation are left untouched, and the roles annotation replaces the resolved roles.
+// A returned error means the node is not in its desired state; use IsPermanent to tell
+// whether retrying can resolve it.
+func (h *CacheResourceHandler) EnsureRole(ctx context.Context, obj interface{}) error {
 	n, ok := obj.(*corev1.Node)
 	if !ok {
 		h.logger.Warn("object is not a Node")
-		return
+		return nil
+	}
+
+	if h.ignored(n) {
+		h.observeOverride(n, true, nil)
+		return nil
 	}
+	pinned, _ := h.pinnedRoles(n)
+	h.observeOverride(n, false, pinned)
+
+	roles, err := h.ensure(ctx, n, pinned)
+	h.recordStatus(ctx, n, roles, err)
+	return err
+}
 
+// ensure applies the role labels and taints to the node, returning the roles applied to it.
+// When the labels fail to apply, the roles of the node's current status are returned.
+func (h *CacheResourceHandler) ensure(ctx context.Context, n *corev1.Node, pinned []string) ([]StatusRole, error) {
 	h.logger.Debug("processing role for node",
 		zap.String("name", n.Name),
-		zap.String("label", h.roleLabel),
+		zap.Strings("sources", h.sources),
+		zap.String("mode", string(h.mode)),
 	)
 
-	// Check if the node has the expected role label
-	val, ok := n.Labels[h.roleLabel]
-	if !ok {
-		h.logger.Debug("node does not have the expected label",
+	desired, rejected := h.resolve(n, pinned)
+	owned := ownedRoles(n, h.fieldManager, h.compiledOutputs)
+	meta := ownedMetadata(n, h.fieldManager, h.metadata)
+	pending := ""
+	switch {
+	case len(desired) == 0:
+		pending = pendingNoRole
+	case rejected:
+		pending = pendingRejected
+	}
+
+	if len(desired) == 0 && len(owned) == 0 && len(meta.labels) == 0 && len(meta.annotations) == 0 {
+		h.logger.Debug("node does not match any of the expected labels or rules",
 			zap.String("name", n.Name),
-			zap.String("want", h.roleLabel),
+			zap.Strings("want", h.sources),
 		)
-		return
+		h.plans.Delete(n.Name)
+		return nil, h.ensureTaints(ctx, n, nil, pending)
 	}
 
-	h.logger.Debug("node has the expected label",
-		zap.String("name", n.Name),
-		zap.String("value", val),
-	)
+	ch := h.diff(n, desired, owned, meta, len(pinned) > 0)
+	roles := h.rolesOf(desired, ch.labels)
+	if !ch.changed {
+		h.plans.Delete(n.Name)
+		return h.statusRoles(desired, ch.labels), h.ensureTaints(ctx, n, roles, pending)
+	}
+
+	// Roles on the node are unchanged when the labels fail to apply
+	current, _ := nodeStatus(n)
 
-	// Check if the node already has the role label
-	roleKey := rolePrefix + val
-	if _, ok := n.Labels[roleKey]; ok {
-		h.logger.Debug("node already has the role label",
+	patchData, err := makeApplyPatch(n.Name, ch.labels, ch.annotations)
+	if err != nil {
+		h.logger.Error("failed to create apply patch",
 			zap.String("node", n.Name),
-			zap.String("roleKey", roleKey),
+			zap.Error(err),
 		)
-		return
+		return current.Roles, &permanentError{err: fmt.Errorf("failed to create apply patch for node %s: %w", n.Name, err)}
 	}
 
-	// Setup the labels to patch: non-nil pointer sets the label, nil deletes it
-	labels := map[string]*string{
-		roleKey: ptr(""),
+	if h.dryRun {
+		if err := h.plan(ctx, n, ch, patchData); err != nil {
+			return nil, err
+		}
+		return nil, h.ensureTaints(ctx, n, roles, pending)
 	}
 
-	if h.replace {
-		for k := range n.Labels {
-			if strings.HasPrefix(k, rolePrefix) {
-				h.logger.Debug("node already has a role label, deleting",
-					zap.String("node", n.Name),
-					zap.String("roleKey", k),
-				)
-				labels[k] = nil
-			}
-		}
+	patched, err := h.patch(ctx, n.Name, patchData)
+	if err != nil {
+		h.reportFailure(n, ch, err)
+		h.reportStartupPending(n, pendingApplyError)
+		return current.Roles, err
 	}
 
-	patchCtx, cancel := context.WithTimeout(ctx, patchTimeout)
-	defer cancel()
+	for _, r := range ch.added {
+		successCounter.Increment(r.Role, r.Source)
+		h.logger.Info("node role label patched successfully",
+			zap.String("node", n.Name),
+			zap.String("role", r.Role),
+			zap.Strings("labels", labelKeys(r.labels)),
+			zap.String("source", r.Source),
+			zap.Bool("replace", h.replace),
+		)
+		h.eventf(n, corev1.EventTypeNormal, eventReasonRoleApplied,
+			"Applied role %q from %s", r.Role, r.Source)
+	}
+
+	for _, k := range ch.removed {
+		r := roleOf(h.compiledOutputs, k, n.Labels[k])
+		removedCounter.Increment(r)
+		h.logger.Info("node role label removed",
+			zap.String("node", n.Name),
+			zap.String("roleKey", k),
+			zap.Bool("gc", h.gc),
+			zap.Bool("replace", h.replace),
+		)
+		h.eventf(n, corev1.EventTypeNormal, eventReasonRoleRemoved,
+			"Removed role %q label %s, it is no longer resolved", r, k)
+	}
+
+	if len(ch.removedMetadata) > 0 {
+		h.logger.Info("node role metadata removed",
+			zap.String("node", n.Name),
+			zap.Strings("keys", ch.removedMetadata),
+			zap.Bool("gc", h.gc),
+			zap.Bool("replace", h.replace),
+		)
+	}
 
-	patchData, err := makePatchMetadata(labels)
+	// Taints are written with the node's resourceVersion, which the apply changed
+	if patched == nil {
+		patched = n
+	}
+	return h.statusRoles(desired, ch.labels), h.ensureTaints(ctx, patched, roles, pending)
+}
+
+// Relevant reports whether an update from oldNode to newNode may change the roles resolved for the node.
+// Label and override annotation changes are always relevant; when rules are configured, changes to the parts of the
+// Node the rules can read (annotations, spec, capacity, nodeInfo) are relevant too.
+func (h *CacheResourceHandler) Relevant(oldNode, newNode *corev1.Node) bool {
+	if !equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels) {
+		return true
+	}
+	if oldNode.Annotations[IgnoreAnnotation] != newNode.Annotations[IgnoreAnnotation] ||
+		oldNode.Annotations[RolesAnnotation] != newNode.Annotations[RolesAnnotation] {
+		return true
+	}
+	if len(h.taints) > 0 && !equality.Semantic.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) {
+		return true
+	}
+	if len(h.compiled) == 0 && len(h.compiledMappings) == 0 {
+		return false
+	}
+	return !equality.Semantic.DeepEqual(oldNode.Annotations, newNode.Annotations) ||
+		!equality.Semantic.DeepEqual(oldNode.Spec, newNode.Spec) ||
+		!equality.Semantic.DeepEqual(oldNode.Status.Capacity, newNode.Status.Capacity) ||
+		!equality.Semantic.DeepEqual(oldNode.Status.Allocatable, newNode.Status.Allocatable) ||
+		!equality.Semantic.DeepEqual(oldNode.Status.NodeInfo, newNode.Status.NodeInfo)
+}
+
+// Forget drops any state kept for a node that no longer exists.
+func (h *CacheResourceHandler) Forget(name string) {
+	h.plans.Delete(name)
+	h.overrides.Delete(name)
+	h.mappingStore.Forget(name)
+	startupPendingGauge.Delete(name)
+}
+
+// plan validates the changes with a dry-run apply and records them without persisting.
+// Validation failures are recorded in the plan and returned so transient ones are retried.
+func (h *CacheResourceHandler) plan(ctx context.Context, n *corev1.Node, ch *changes, patchData []byte) error {
+	if len(ch.added) == 0 && len(ch.removed) == 0 && len(ch.removedMetadata) == 0 {
+		h.plans.Delete(n.Name)
+		return nil
+	}
+
+	p := Plan{
+		Node:   n.Name,
+		Remove: append(append([]string{}, ch.removed...), ch.removedMetadata...),
+		Time:   time.Now().UTC(),
+	}
+	for _, r := range ch.added {
+		p.Add = append(p.Add, labelKeys(r.labels)...)
+	}
+
+	_, err := h.patch(ctx, n.Name, patchData)
 	if err != nil {
-		h.logger.Error("failed to create patch metadata",
+		p.Error = err.Error()
+		h.logger.Warn("planned role changes would fail",
 			zap.String("node", n.Name),
+			zap.Strings("add", p.Add),
+			zap.Strings("remove", p.Remove),
 			zap.Error(err),
 		)
+	} else {
+		h.logger.Info("planned role changes (dry run)",
+			zap.String("node", n.Name),
+			zap.Strings("add", p.Add),
+			zap.Strings("remove", p.Remove),
+		)
+	}
+
+	h.plans.Set(p)
+	return err
+}
+
+// reportMappingConflict records a conflict for the mapping that produced the resolution, if any.
+func (h *CacheResourceHandler) reportMappingConflict(n *corev1.Node, r Resolution, msg string) {
+	name, ok := mappingName(r)
+	if !ok {
 		return
 	}
+	h.mappingStore.conflict(name, n.Name, msg)
+	h.logger.Debug("mapping conflict",
+		zap.String("mapping", name),
+		zap.String("node", n.Name),
+		zap.String("conflict", msg),
+	)
+}
+
+// reportFailure records metrics and logs for a failed apply.
+func (h *CacheResourceHandler) reportFailure(n *corev1.Node, ch *changes, err error) {
+	reason := reasonAPIError
+	switch {
+	case apierrors.IsInvalid(err):
+		reason = reasonInvalidRole
+	case apierrors.IsConflict(err):
+		reason = reasonConflict
+	}
 
-	op := func() error {
-		if _, patchErr := h.patcher(
-			patchCtx, n.Name,
-			types.StrategicMergePatchType,
-			patchData,
-			metav1.PatchOptions{},
-		); patchErr != nil {
-			if apierrors.IsForbidden(patchErr) || apierrors.IsNotFound(patchErr) || apierrors.IsInvalid(patchErr) {
-				return backoff.Permanent(fmt.Errorf("non-retryable error patching node %s: %w", n.Name, patchErr))
+	if apierrors.IsConflict(err) {
+		conflicting := map[string]bool{}
+		for _, k := range conflictingLabels(err) {
+			conflicting[k] = true
+			conflictCounter.Increment(roleOf(h.compiledOutputs, k, ch.labels[k]))
+		}
+		for _, r := range ch.added {
+			for _, l := range r.labels {
+				if conflicting[l.key] {
+					h.reportMappingConflict(n, r.Resolution, fmt.Sprintf("role %s on node %s is owned by another field manager", r.Role, n.Name))
+					break
+				}
 			}
-			return fmt.Errorf("failed to patch node %s: %w", n.Name, patchErr)
 		}
-		return nil
+		h.logger.Error("role labels are owned by another field manager",
+			zap.String("node", n.Name),
+			zap.String("fieldManager", h.fieldManager),
+			zap.Strings("labels", conflictingLabels(err)),
+			zap.Error(err),
+		)
 	}
 
-	expBackoff := backoff.NewExponentialBackOff()
-	expBackoff.MaxElapsedTime = patchTimeout
-	if err := backoff.Retry(op, backoff.WithContext(expBackoff, patchCtx)); err != nil {
-		failureCounter.Increment(val)
-		h.logger.Error("patch node failed after backoff",
+	for _, r := range ch.added {
+		failureCounter.Increment(r.Role, r.Source, reason)
+		h.logger.Error("patch node failed",
 			zap.String("node", n.Name),
-			zap.String("roleKey", roleKey),
+			zap.String("role", r.Role),
+			zap.Strings("labels", labelKeys(r.labels)),
+			zap.String("source", r.Source),
 			zap.Bool("replace", h.replace),
 			zap.Error(err),
 		)
-		return
 	}
+	if len(ch.added) == 0 {
+		h.logger.Error("patch node failed",
+			zap.String("node", n.Name),
+			zap.Strings("removed", ch.removed),
+			zap.Error(err),
+		)
+	}
+	h.eventf(n, corev1.EventTypeWarning, eventReasonPatchFailed,
+		"Failed to apply role labels: %v", err)
+}
 
-	successCounter.Increment(val)
+// eventf records an event on the node, if a recorder is set.
+func (h *CacheResourceHandler) eventf(n *corev1.Node, eventType, reason, msg string, args ...any) {
+	if h.recorder != nil {
+		h.recorder.Eventf(n, eventType, reason, msg, args...)
+	}
+}
 
-	h.logger.Info("node role label patched successfully",
+// reportInvalid records metrics, logs and a Node event for a role that is not a valid label name.
+func (h *CacheResourceHandler) reportInvalid(n *corev1.Node, r Resolution, err error) {
+	failureCounter.Increment(r.Role, r.Source, reasonInvalidRole)
+	h.logger.Warn("ignoring invalid role",
 		zap.String("node", n.Name),
-		zap.String("roleKey", roleKey),
-		zap.Bool("replace", h.replace),
+		zap.String("role", r.Role),
+		zap.String("source", r.Source),
+		zap.Error(err),
 	)
+	h.eventf(n, corev1.EventTypeWarning, eventReasonInvalidRole,
+		"Ignoring role %q resolved from %s: %v", r.Role, r.Source, err)
 }
 
-func ptr(s string) *string {
-	return &s
+// transform runs the transform chain on the values of the source labels.
+// Values the chain turns empty, with no default role, are left out.
+func (h *CacheResourceHandler) transform(n *corev1.Node, resolved []Resolution) []Resolution {
+	if len(h.compiledTransforms) == 0 && h.defaultRole == "" {
+		return resolved
+	}
+	res := make([]Resolution, 0, len(resolved))
+	for _, r := range resolved {
+		if r.Role = transformValue(r.Value, h.compiledTransforms, h.defaultRole); r.Role == "" {
+			h.logger.Debug("source value transformed to no role",
+				zap.String("name", n.Name),
+				zap.String("source", r.Source),
+				zap.String("value", r.Value),
+			)
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
 }
 
-// patchPayload represents the JSON structure for a Kubernetes strategic merge patch.
-type patchPayload struct {
-	Metadata patchMetadata `json:"metadata"`
+// resolve returns the desired roles for the node in priority order, resolved from the
+// trusted source labels in priority order and then from the rules and mappings, or the pinned roles if any.
+// Roles are normalized, and the protected ones or the ones with invalid output labels are reported and
+// left out, in which case rejected is set.
+func (h *CacheResourceHandler) resolve(n *corev1.Node, pinned []string) (desired []desiredRole, rejected bool) {
+	var resolved []Resolution
+	if len(pinned) > 0 {
+		for _, r := range pinned {
+			resolved = append(resolved, Resolution{Role: r, Source: RolesAnnotation, Value: r})
+		}
+		h.mappingStore.observe(n.Name, nil)
+	} else {
+		resolved = h.transform(n, resolveSources(n, h.trustedSources(n), h.mode, h.sep))
+		resolved = append(resolved, evaluateRules(n, h.compiled, h.logger)...)
+
+		// Mappings have the lowest priority; a role they resolve that is already
+		// resolved from another source is reported as a conflict of the mapping
+		mapped, matched := h.evaluateMappings(n)
+		resolved = append(resolved, mapped...)
+		h.mappingStore.observe(n.Name, matched)
+	}
+
+	desired = make([]desiredRole, 0, len(resolved))
+	sources := make(map[string]string, len(resolved))
+	for _, r := range resolved {
+		r.Role = h.normalize.apply(r.Role)
+		if prev, dup := sources[r.Role]; dup {
+			if prev != r.Source {
+				h.reportMappingConflict(n, r, fmt.Sprintf("role %s on node %s is also resolved from %s", r.Role, n.Name, prev))
+			}
+			continue
+		}
+		if h.compiledProtection.protected(r.Role) {
+			h.reportProtected(n, r.Role, r.Source, operationAdd)
+			rejected = true
+			continue
+		}
+
+		labels, err := renderLabels(h.compiledOutputs, r.Role)
+		if err != nil {
+			h.reportInvalid(n, r, err)
+			rejected = true
+			continue
+		}
+		sources[r.Role] = r.Source
+		desired = append(desired, desiredRole{Resolution: r, labels: labels})
+
+		h.logger.Debug("node resolved role",
+			zap.String("name", n.Name),
+			zap.String("source", r.Source),
+			zap.String("value", r.Value),
+			zap.String("role", r.Role),
+		)
+	}
+	return desired, rejected
+}
+
+// changes are the updates computed for a node.
+type changes struct {
+	// labels is the full set of labels the field manager applies (and owns) on the node.
+	// Owned labels omitted from the set are removed by the API server.
+	labels map[string]string
+	// annotations is the full set of annotations the field manager applies on the node.
+	annotations map[string]string
+	added       []desiredRole
+	removed     []string
+	// removedMetadata are the keys of the extra labels and annotations removed from the node.
+	removedMetadata []string
+	changed         bool
+}
+
+// diff computes the labels to apply to bring the node to the desired roles, writing every output
+// for each role. An output with a fixed key is written for the first role in priority order.
+// Only labels owned by the field manager are ever removed, for every output alike: always in GC mode,
+// and in replace mode whenever the node resolves any role, so the owned labels match the desired set.
+// Pinned roles are reconciled as in replace mode. Nothing is removed while the mappings are loading. Owned labels of protected roles are never removed.
+// The extra labels and annotations of the roles follow the same rules, and a key is written for the
+// first role in priority order that sets it.
+func (h *CacheResourceHandler) diff(n *corev1.Node, desired []desiredRole, owned map[string]bool, meta ownedFields, pinned bool) *changes {
+	ch := &changes{
+		labels:      make(map[string]string, len(desired)*len(h.compiledOutputs)),
+		annotations: map[string]string{},
+	}
+
+	for _, r := range desired {
+		missing := false
+		for _, l := range r.labels {
+			if _, taken := ch.labels[l.key]; taken {
+				continue
+			}
+			ch.labels[l.key] = l.value
+
+			// Check if the node already has the label
+			if v, ok := n.Labels[l.key]; ok && v == l.value {
+				h.logger.Debug("node already has the role label",
+					zap.String("node", n.Name),
+					zap.String("roleKey", l.key),
+				)
+				continue
+			}
+			missing = true
+		}
+		if missing {
+			ch.added = append(ch.added, r)
+		}
+	}
+
+	// Role labels take precedence over the extra labels of other roles
+	added := make(map[string]bool, len(ch.added))
+	for _, r := range ch.added {
+		added[r.Role] = true
+	}
+	for _, r := range desired {
+		if h.addMetadata(ch, r, n.Labels, n.Annotations) && !added[r.Role] {
+			added[r.Role] = true
+			ch.added = append(ch.added, r)
+		}
+	}
+
+	drop := !h.mappingsPending && (h.gc || ((h.replace || pinned) && len(desired) > 0))
+	removalSource := "replace"
+	switch {
+	case h.gc:
+		removalSource = "gc"
+	case pinned && !h.replace:
+		removalSource = RolesAnnotation
+	}
+	for _, roleKey := range sortedKeys(owned) {
+		if _, ok := ch.labels[roleKey]; ok {
+			continue
+		}
+		val, ok := n.Labels[roleKey]
+		if !ok {
+			continue
+		}
+		if !drop {
+			ch.labels[roleKey] = val
+			continue
+		}
+		if role := roleOf(h.compiledOutputs, roleKey, val); h.compiledProtection.protected(role) {
+			h.reportProtected(n, role, removalSource, operationRemove)
+			ch.labels[roleKey] = val
+			continue
+		}
+		h.logger.Debug("owned role label no longer resolved, deleting",
+			zap.String("node", n.Name),
+			zap.String("roleKey", roleKey),
+		)
+		ch.removed = append(ch.removed, roleKey)
+	}
+
+	ch.removedMetadata = append(ch.removedMetadata, dropOwned(ch.labels, meta.labels, n.Labels, drop)...)
+	ch.removedMetadata = append(ch.removedMetadata, dropOwned(ch.annotations, meta.annotations, n.Annotations, drop)...)
+
+	// Apply when roles change, or to take ownership of desired roles already on the node
+	ownedLabels := make(map[string]bool, len(owned)+len(meta.labels))
+	for k := range owned {
+		ownedLabels[k] = true
+	}
+	for k := range meta.labels {
+		ownedLabels[k] = true
+	}
+	ch.changed = len(ch.added) > 0 || len(ch.removed) > 0 || len(ch.removedMetadata) > 0 ||
+		!sameKeys(ch.labels, ownedLabels) || !sameKeys(ch.annotations, meta.annotations)
+	return ch
+}
+
+// dropOwned keeps the owned keys missing from the applied set, unless drop is set,
+// in which case they are returned for removal.
+func dropOwned(applied map[string]string, owned map[string]bool, current map[string]string, drop bool) []string {
+	var removed []string
+	for _, k := range sortedKeys(owned) {
+		if _, ok := applied[k]; ok {
+			continue
+		}
+		val, ok := current[k]
+		if !ok {
+			continue
+		}
+		if !drop {
+			applied[k] = val
+			continue
+		}
+		removed = append(removed, k)
+	}
+	return removed
+}
+
+// patch applies the patch data to the node using server-side apply, returning the patched node, if any.
+// Errors that retrying cannot resolve are wrapped as permanent.
+func (h *CacheResourceHandler) patch(ctx context.Context, name string, patchData []byte) (*corev1.Node, error) {
+	patchCtx, cancel := context.WithTimeout(ctx, patchTimeout)
+	defer cancel()
+
+	opts := metav1.PatchOptions{
+		FieldManager: h.fieldManager,
+		Force:        &h.force,
+	}
+	if h.dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+
+	node, err := h.patcher(patchCtx, name, types.ApplyPatchType, patchData, opts)
+	if err != nil {
+		if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) ||
+			apierrors.IsInvalid(err) || apierrors.IsConflict(err) {
+			return nil, &permanentError{err: fmt.Errorf("non-retryable error patching node %s: %w", name, err)}
+		}
+		return nil, fmt.Errorf("failed to patch node %s: %w", name, err)
+	}
+	return node, nil
 }
 
-type patchMetadata struct {
-	Labels map[string]*string `json:"labels"`
+// labelKeys returns the keys of the labels.
+func labelKeys(labels []label) []string {
+	keys := make([]string, 0, len(labels))
+	for _, l := range labels {
+		keys = append(keys, l.key)
+	}
+	return keys
+}
+
+// conflictingLabels returns the label keys reported in a server-side apply conflict.
+func conflictingLabels(err error) []string {
+	var status apierrors.APIStatus
+	if !errors.As(err, &status) || status.Status().Details == nil {
+		return nil
+	}
+	var keys []string
+	for _, c := range status.Status().Details.Causes {
+		if c.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		if k, ok := strings.CutPrefix(c.Field, ".metadata.labels."); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// sameKeys reports whether the two maps have the same set of keys.
+func sameKeys[A, B any](a map[string]A, b map[string]B) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
-// makePatchMetadata creates a JSON patch for the given role labels.
-// A non-nil string pointer sets the label; a nil pointer deletes it.
-func makePatchMetadata(labels map[string]*string) ([]byte, error) {
-	return json.Marshal(patchPayload{
-		Metadata: patchMetadata{Labels: labels},
-	})
+// sortedKeys returns the keys of the map in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// makeApplyPatch creates a server-side apply patch for the node with the given labels and annotations.
+func makeApplyPatch(name string, labels, annotations map[string]string) ([]byte, error) {
+	node := corev1ac.Node(name)
+	if len(labels) > 0 {
+		node.WithLabels(labels)
+	}
+	if len(annotations) > 0 {
+		node.WithAnnotations(annotations)
+	}
+	return json.Marshal(node)
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 )
 
 func getTestNode(name string, labels map[string]string) *corev1.Node {
@@ -24,6 +26,36 @@ func getTestNode(name string, labels map[string]string) *corev1.Node {
 	}
 }
 
+// withOwnedLabels records the label keys as applied by the field manager in the node's managedFields.
+func withOwnedLabels(n *corev1.Node, manager string, keys ...string) *corev1.Node {
+	labels := map[string]any{}
+	for _, k := range keys {
+		labels[fieldsKeyPrefix+k] = map[string]any{}
+	}
+	raw, _ := json.Marshal(map[string]any{fieldsMetadata: map[string]any{fieldsLabels: labels}})
+	n.ManagedFields = append(n.ManagedFields, metav1.ManagedFieldsEntry{
+		Manager:    manager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: raw},
+	})
+	return n
+}
+
+// getAppliedLabels decodes the labels from a server-side apply patch.
+func getAppliedLabels(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	var n corev1.Node
+	if err := json.Unmarshal(data, &n); err != nil {
+		t.Fatalf("failed to unmarshal patch: %v", err)
+	}
+	if n.Kind != "Node" || n.APIVersion != "v1" {
+		t.Fatalf("unexpected apply patch type: %s/%s", n.APIVersion, n.Kind)
+	}
+	return n.Labels
+}
+
 func newTestPatcher(retNode *corev1.Node, retErr error) NodePatcher {
 	return func(_ context.Context, _ string, _ types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
 		return retNode, retErr
@@ -34,16 +66,25 @@ func TestNewCacheResourceHandler_Validation(t *testing.T) {
 	logger := logger.GetTestLogger()
 	patcher := newTestPatcher(nil, nil)
 
-	if _, err := NewCacheResourceHandler(nil, logger, "label", false); err == nil {
+	if _, err := NewCacheResourceHandler(nil, logger, WithSources("label")); err == nil {
 		t.Error("expected error for nil patcher")
 	}
-	if _, err := NewCacheResourceHandler(patcher, nil, "label", false); err == nil {
+	if _, err := NewCacheResourceHandler(patcher, nil, WithSources("label")); err == nil {
 		t.Error("expected error for nil logger")
 	}
-	if _, err := NewCacheResourceHandler(patcher, logger, "", false); err == nil {
+	if _, err := NewCacheResourceHandler(patcher, logger); err == nil {
+		t.Error("expected error for missing sources")
+	}
+	if _, err := NewCacheResourceHandler(patcher, logger, WithSources("")); err == nil {
 		t.Error("expected error for empty label")
 	}
-	h, err := NewCacheResourceHandler(patcher, logger, "label", false)
+	if _, err := NewCacheResourceHandler(patcher, logger, WithSources("label"), WithSourceMode("bogus")); err == nil {
+		t.Error("expected error for invalid source mode")
+	}
+	if _, err := NewCacheResourceHandler(patcher, logger, WithSources("label"), WithSeparator(",")); err == nil {
+		t.Error("expected error for a separator no label value can contain")
+	}
+	h, err := NewCacheResourceHandler(patcher, logger, WithSources("label"))
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -95,11 +136,17 @@ func TestEnsureRole_PatchVariants(t *testing.T) {
 				gotLabels = data
 				return tt.node, tt.patchErr
 			}
-			h, err := NewCacheResourceHandler(patcher, logger, "test-label", tt.replace)
+			h, err := NewCacheResourceHandler(patcher, logger, WithSources("test-label"), WithReplace(tt.replace))
 			if err != nil {
 				t.Fatalf("failed to create handler: %v", err)
 			}
-			h.EnsureRole(context.Background(), tt.node)
+			err = h.EnsureRole(context.Background(), tt.node)
+			if (err != nil) != (tt.patchErr != nil) {
+				t.Errorf("EnsureRole() error = %v, want error %v", err, tt.patchErr != nil)
+			}
+			if tt.patchErr != nil && !IsPermanent(err) {
+				t.Errorf("expected %v to be permanent", err)
+			}
 			if tt.wantPatch && !called {
 				t.Error("patcher was not called when expected")
 			}
@@ -120,7 +167,7 @@ func TestEnsureRole_NonNodeObject(t *testing.T) {
 		called = true
 		return nil, nil
 	}
-	h, err := NewCacheResourceHandler(patcher, logger, "test-label", false)
+	h, err := NewCacheResourceHandler(patcher, logger, WithSources("test-label"))
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -133,40 +180,217 @@ func TestEnsureRole_NonNodeObject(t *testing.T) {
 func TestEnsureRole_ReplaceRemovesOldRoles(t *testing.T) {
 	logger := logger.GetTestLogger()
 	var gotPatchData []byte
-	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+	var gotType types.PatchType
+	var gotOpts metav1.PatchOptions
+	patcher := func(_ context.Context, _ string, pt types.PatchType, data []byte, opts metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
 		gotPatchData = data
+		gotType = pt
+		gotOpts = opts
 		return nil, nil
 	}
-	node := getTestNode("n1", map[string]string{
-		"test-label":         "worker",
-		rolePrefix + "old":   "",
-		rolePrefix + "stale": "",
-	})
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"test-label":                 "worker",
+		rolePrefix + "old":           "",
+		rolePrefix + "stale":         "",
+		rolePrefix + "control-plane": "",
+	}), fieldManagerDefault, rolePrefix+"old", rolePrefix+"stale")
 
-	h, err := NewCacheResourceHandler(patcher, logger, "test-label", true)
+	h, err := NewCacheResourceHandler(patcher, logger, WithSources("test-label"), WithReplace(true))
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
 	h.EnsureRole(context.Background(), node)
 
-	var patch patchPayload
-	if err := json.Unmarshal(gotPatchData, &patch); err != nil {
-		t.Fatalf("failed to unmarshal patch: %v", err)
+	if gotType != types.ApplyPatchType {
+		t.Errorf("expected apply patch, got %s", gotType)
 	}
+	if gotOpts.FieldManager != fieldManagerDefault {
+		t.Errorf("expected field manager %s, got %s", fieldManagerDefault, gotOpts.FieldManager)
+	}
+
+	// Only the new role is applied: owned old roles are dropped, and the
+	// unowned control-plane role is left to its own manager
+	labels := getAppliedLabels(t, gotPatchData)
+	if len(labels) != 1 {
+		t.Fatalf("expected only the worker role to be applied, got %v", labels)
+	}
+	if v, ok := labels[rolePrefix+"worker"]; !ok || v != "" {
+		t.Errorf("expected worker role to be set, got %v", labels)
+	}
+}
+
+func TestEnsureRole_NoReplaceKeepsOwnedRoles(t *testing.T) {
+	logger := logger.GetTestLogger()
+	var gotPatchData []byte
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		gotPatchData = data
+		return nil, nil
+	}
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"test-label":       "worker",
+		rolePrefix + "old": "",
+	}), "custom-manager", rolePrefix+"old")
 
-	// New role should be set
-	if v, ok := patch.Metadata.Labels[rolePrefix+"worker"]; !ok || v == nil || *v != "" {
-		t.Errorf("expected worker role to be set, got %v", patch.Metadata.Labels)
+	h, err := NewCacheResourceHandler(patcher, logger, WithSources("test-label"), WithFieldManager("custom-manager"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
 	}
+	h.EnsureRole(context.Background(), node)
 
-	// Old roles should be null (deleted)
-	for _, old := range []string{rolePrefix + "old", rolePrefix + "stale"} {
-		if v, ok := patch.Metadata.Labels[old]; !ok || v != nil {
-			t.Errorf("expected %s to be null (deleted), got %v", old, v)
+	labels := getAppliedLabels(t, gotPatchData)
+	for _, k := range []string{rolePrefix + "worker", rolePrefix + "old"} {
+		if _, ok := labels[k]; !ok {
+			t.Errorf("expected %s to be applied, got %v", k, labels)
 		}
 	}
 }
 
+func TestEnsureRole_ReplaceReconcilesRoleSet(t *testing.T) {
+	// The node had gpu and ingress applied from nodeGroup=gpu_ingress, and the value is now gpu
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"nodeGroup":            "gpu",
+		rolePrefix + "gpu":     "",
+		rolePrefix + "ingress": "",
+	}), fieldManagerDefault, rolePrefix+"gpu", rolePrefix+"ingress")
+
+	tests := []struct {
+		name    string
+		replace bool
+		want    []string
+	}{
+		{name: "replace removes roles no longer resolved", replace: true, want: []string{rolePrefix + "gpu"}},
+		{name: "no replace keeps owned roles", replace: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPatchData []byte
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				gotPatchData = data
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"), WithSeparator("_"), WithReplace(tt.replace))
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.want == nil {
+				if gotPatchData != nil {
+					t.Errorf("expected no patch, got %s", gotPatchData)
+				}
+				return
+			}
+			labels := getAppliedLabels(t, gotPatchData)
+			if len(labels) != len(tt.want) {
+				t.Fatalf("expected %v to be applied, got %v", tt.want, labels)
+			}
+			for _, k := range tt.want {
+				if _, ok := labels[k]; !ok {
+					t.Errorf("expected %s to be applied, got %v", k, labels)
+				}
+			}
+		})
+	}
+}
+
+func TestEnsureRole_AlreadyOwned(t *testing.T) {
+	called := false
+	patcher := func(_ context.Context, _ string, _ types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		called = true
+		return nil, nil
+	}
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"test-label":          "worker",
+		rolePrefix + "worker": "",
+	}), fieldManagerDefault, rolePrefix+"worker")
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(), WithSources("test-label"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	h.EnsureRole(context.Background(), node)
+	if called {
+		t.Error("patcher should not be called when the node already has its owned roles")
+	}
+}
+
+func TestEnsureRole_Conflict(t *testing.T) {
+	calls := 0
+	conflict := apierrors.NewApplyConflict([]metav1.StatusCause{{
+		Type:    metav1.CauseTypeFieldManagerConflict,
+		Message: `conflict with "kubeadm"`,
+		Field:   ".metadata.labels." + rolePrefix + "worker",
+	}}, "conflict")
+	patcher := func(_ context.Context, _ string, _ types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		calls++
+		return nil, conflict
+	}
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(), WithSources("test-label"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	err = h.EnsureRole(context.Background(), getTestNode("n1", map[string]string{"test-label": "worker"}))
+	if !IsPermanent(err) {
+		t.Errorf("expected conflict to be permanent, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected conflict not to be retried, got %d calls", calls)
+	}
+	if got := conflictingLabels(conflict); len(got) != 1 || got[0] != rolePrefix+"worker" {
+		t.Errorf("unexpected conflicting labels: %v", got)
+	}
+}
+
+func TestEnsureRole_SourceModes(t *testing.T) {
+	logger := logger.GetTestLogger()
+	node := getTestNode("n1", map[string]string{
+		"karpenter.sh/nodepool": "gpu",
+		"nodeGroup":             "ingress",
+	})
+
+	tests := []struct {
+		name string
+		mode SourceMode
+		want []string
+	}{
+		{name: "first match wins", mode: SourceModeFirst, want: []string{rolePrefix + "ingress"}},
+		{name: "all matches win", mode: SourceModeAll, want: []string{rolePrefix + "ingress", rolePrefix + "gpu"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPatchData []byte
+			patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				gotPatchData = data
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger,
+				WithSources("nodeGroup", "karpenter.sh/nodepool"),
+				WithSourceMode(tt.mode),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			h.EnsureRole(context.Background(), node)
+
+			labels := getAppliedLabels(t, gotPatchData)
+			if len(labels) != len(tt.want) {
+				t.Fatalf("expected %d labels, got %v", len(tt.want), labels)
+			}
+			for _, k := range tt.want {
+				if _, ok := labels[k]; !ok {
+					t.Errorf("expected %s to be set, got %v", k, labels)
+				}
+			}
+		})
+	}
+}
+
 func TestEnsureRole_ContextCancellation(t *testing.T) {
 	logger := logger.GetTestLogger()
 	callCount := 0
@@ -176,7 +400,7 @@ func TestEnsureRole_ContextCancellation(t *testing.T) {
 	}
 	node := getTestNode("n1", map[string]string{"test-label": "worker"})
 
-	h, err := NewCacheResourceHandler(patcher, logger, "test-label", false)
+	h, err := NewCacheResourceHandler(patcher, logger, WithSources("test-label"))
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -184,44 +408,145 @@ func TestEnsureRole_ContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
 
-	h.EnsureRole(ctx, node)
-	// Should have retried at least once but stopped due to context timeout
+	err = h.EnsureRole(ctx, node)
 	if callCount < 1 {
 		t.Error("expected at least one patch attempt")
 	}
+	// Transient errors are returned for the caller to requeue
+	if err == nil || IsPermanent(err) {
+		t.Errorf("expected transient error, got %v", err)
+	}
 }
 
-func TestMakePatchMetadata(t *testing.T) {
+func TestMakeApplyPatch(t *testing.T) {
 	tests := []struct {
-		name  string
-		input map[string]*string
-		want  string
+		name        string
+		input       map[string]string
+		annotations map[string]string
+		want        string
 	}{
 		{
-			name:  "single add",
-			input: map[string]*string{"foo": ptr("")},
-			want:  `{"metadata":{"labels":{"foo":""}}}`,
+			name:  "single",
+			input: map[string]string{"foo": ""},
+			want:  `{"kind":"Node","apiVersion":"v1","metadata":{"name":"n1","labels":{"foo":""}}}`,
+		},
+		{
+			name:  "multiple",
+			input: map[string]string{"bar": "", "foo": ""},
+			want:  `{"kind":"Node","apiVersion":"v1","metadata":{"name":"n1","labels":{"bar":"","foo":""}}}`,
 		},
 		{
-			name:  "multiple add",
-			input: map[string]*string{"bar": ptr(""), "foo": ptr("")},
-			want:  `{"metadata":{"labels":{"bar":"","foo":""}}}`,
+			name:        "annotations",
+			input:       map[string]string{"foo": ""},
+			annotations: map[string]string{"cost-center": "ml"},
+			want:        `{"kind":"Node","apiVersion":"v1","metadata":{"name":"n1","labels":{"foo":""},"annotations":{"cost-center":"ml"}}}`,
 		},
 		{
-			name:  "add and remove",
-			input: map[string]*string{"bar": nil, "foo": ptr("")},
-			want:  `{"metadata":{"labels":{"bar":null,"foo":""}}}`,
+			name: "none",
+			want: `{"kind":"Node","apiVersion":"v1","metadata":{"name":"n1"}}`,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := makePatchMetadata(tt.input)
+			got, err := makeApplyPatch("n1", tt.input, tt.annotations)
 			if err != nil {
-				t.Fatalf("makePatchMetadata() error = %v", err)
+				t.Fatalf("makeApplyPatch() error = %v", err)
 			}
 			if string(got) != tt.want {
-				t.Errorf("makePatchMetadata() = %s, want %s", got, tt.want)
+				t.Errorf("makeApplyPatch() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelevant(t *testing.T) {
+	withLabels, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(), WithSources("test-label"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	withRules, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(),
+		WithRules(Rule{Name: "gpu", Expression: "node.status.allocatable['nvidia.com/gpu'] > 0", Roles: []string{"gpu"}}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	oldNode := getTestNode("n1", map[string]string{"test-label": "worker"})
+	relabeled := getTestNode("n1", map[string]string{"test-label": "gpu"})
+	tainted := getTestNode("n1", map[string]string{"test-label": "worker"})
+	tainted.Spec.Taints = []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}
+	heartbeat := getTestNode("n1", map[string]string{"test-label": "worker"})
+	heartbeat.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+
+	tests := []struct {
+		name    string
+		h       *CacheResourceHandler
+		newNode *corev1.Node
+		want    bool
+	}{
+		{name: "labels changed", h: withLabels, newNode: relabeled, want: true},
+		{name: "spec changed without rules", h: withLabels, newNode: tainted, want: false},
+		{name: "spec changed with rules", h: withRules, newNode: tainted, want: true},
+		{name: "conditions changed with rules", h: withRules, newNode: heartbeat, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.Relevant(oldNode, tt.newNode); got != tt.want {
+				t.Errorf("Relevant() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureRole_Events(t *testing.T) {
+	node := withOwnedLabels(getTestNode("n1", map[string]string{
+		"nodeGroup":            "gpu",
+		rolePrefix + "ingress": "",
+	}), fieldManagerDefault, rolePrefix+"ingress")
+
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{
+			name: "applied and removed",
+			want: []string{"Normal " + eventReasonRoleApplied + ` Applied role "gpu"`, "Normal " + eventReasonRoleRemoved + ` Removed role "ingress"`},
+		},
+		{
+			name: "failed",
+			err:  errors.New("connection refused"),
+			want: []string{"Warning " + eventReasonPatchFailed + " Failed to apply role labels"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			h, err := NewCacheResourceHandler(newTestPatcher(nil, tt.err), logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithReplace(true),
+				WithRecorder(recorder),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), node); (err != nil) != (tt.err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var got []string
+			for len(recorder.Events) > 0 {
+				got = append(got, <-recorder.Events)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %d events, got %v", len(tt.want), got)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("expected event %q, got %q", want, got[i])
+				}
 			}
 		})
 	}
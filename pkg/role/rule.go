@@ -0,0 +1,176 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	ruleVariable  = "node"
+	ruleSeparator = "->"
+	ruleSource    = "rule:"
+)
+
+// Rule maps a CEL expression evaluated against the Node to one or more roles.
+// The Node is available to the expression as the `node` variable, with the
+// resource quantities in status.capacity and status.allocatable exposed as integers.
+type Rule struct {
+	// Name identifies the rule in logs and metrics.
+	Name string
+	// Expression is a CEL expression that must evaluate to a bool.
+	Expression string
+	// Roles are the roles emitted when the expression evaluates to true.
+	Roles []string
+}
+
+// ParseRule parses a rule in the `<expression> -> <role>[,<role>...]` format.
+func ParseRule(name, s string) (Rule, error) {
+	idx := strings.LastIndex(s, ruleSeparator)
+	if idx < 0 {
+		return Rule{}, fmt.Errorf("rule %q must be in the format '<expression> %s <role>[,<role>...]'", s, ruleSeparator)
+	}
+
+	r := Rule{
+		Name:       name,
+		Expression: strings.TrimSpace(s[:idx]),
+	}
+	for _, role := range strings.Split(s[idx+len(ruleSeparator):], ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			r.Roles = append(r.Roles, role)
+		}
+	}
+	return r, nil
+}
+
+// compiledRule is a Rule with its type-checked CEL program.
+type compiledRule struct {
+	Rule
+	source  string
+	program cel.Program
+}
+
+// ValidateRules compiles and type-checks the rules and reports all errors found.
+func ValidateRules(rules ...Rule) error {
+	_, err := compileRules(rules)
+	return err
+}
+
+// compileRules compiles and type-checks the rules.
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	env, err := cel.NewEnv(
+		cel.Variable(ruleVariable, cel.MapType(cel.StringType, cel.DynType)),
+		ext.Strings(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CEL environment: %w", err)
+	}
+
+	var errs []error
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, r := range rules {
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("rule %q: name must not be empty", r.Expression))
+			continue
+		}
+		if len(r.Roles) == 0 {
+			errs = append(errs, fmt.Errorf("rule %s: at least one role must be specified", r.Name))
+			continue
+		}
+
+		ast, iss := env.Compile(r.Expression)
+		if iss.Err() != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", r.Name, iss.Err()))
+			continue
+		}
+		if t := ast.OutputType(); !t.IsExactType(cel.BoolType) && !t.IsExactType(cel.DynType) {
+			errs = append(errs, fmt.Errorf("rule %s: expression must evaluate to bool, got %s", r.Name, t))
+			continue
+		}
+
+		prg, err := env.Program(ast)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", r.Name, err))
+			continue
+		}
+		compiled = append(compiled, compiledRule{Rule: r, source: ruleSource + r.Name, program: prg})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return compiled, nil
+}
+
+// evaluateRules returns the roles emitted by every rule that matches the node.
+// Evaluation errors (e.g. missing map keys) are treated as a non-match.
+func evaluateRules(n *corev1.Node, rules []compiledRule, logger *zap.Logger) []Resolution {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	obj, err := nodeToMap(n)
+	if err != nil {
+		logger.Error("failed to convert node for rule evaluation",
+			zap.String("node", n.Name),
+			zap.Error(err),
+		)
+		return nil
+	}
+
+	var res []Resolution
+	for _, r := range rules {
+		out, _, err := r.program.Eval(map[string]any{ruleVariable: obj})
+		if err != nil {
+			logger.Debug("rule evaluation failed, treating as no match",
+				zap.String("node", n.Name),
+				zap.String("rule", r.Name),
+				zap.Error(err),
+			)
+			continue
+		}
+		if matched, ok := out.Value().(bool); !ok || !matched {
+			continue
+		}
+		for _, role := range r.Roles {
+			res = append(res, Resolution{Role: role, Source: r.source})
+		}
+	}
+	return res
+}
+
+// nodeToMap converts the node into the map exposed to CEL expressions.
+func nodeToMap(n *corev1.Node) (map[string]any, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(n)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert node %s: %w", n.Name, err)
+	}
+
+	// Expose quantities as integers so expressions can compare them numerically
+	status, _ := obj["status"].(map[string]any)
+	if status == nil {
+		status = map[string]any{}
+		obj["status"] = status
+	}
+	status["capacity"] = quantities(n.Status.Capacity)
+	status["allocatable"] = quantities(n.Status.Allocatable)
+	return obj, nil
+}
+
+func quantities(list corev1.ResourceList) map[string]any {
+	m := make(map[string]any, len(list))
+	for k, v := range list {
+		m[string(k)] = v.Value()
+	}
+	return m
+}
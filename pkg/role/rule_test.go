@@ -0,0 +1,140 @@
+package role
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func getTestGPUNode(name string, gpus int64) *corev1.Node {
+	n := getTestNode(name, map[string]string{"kubernetes.io/os": "linux"})
+	n.Spec.Taints = []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}
+	n.Status.Allocatable = corev1.ResourceList{
+		"nvidia.com/gpu":      *resource.NewQuantity(gpus, resource.DecimalSI),
+		corev1.ResourceMemory: resource.MustParse("16Gi"),
+	}
+	return n
+}
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		expr    string
+		roles   []string
+		wantErr bool
+	}{
+		{in: "node.metadata.name == 'a' -> gpu", expr: "node.metadata.name == 'a'", roles: []string{"gpu"}},
+		{in: "true->gpu, ingress", expr: "true", roles: []string{"gpu", "ingress"}},
+		{in: "true", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseRule("test", tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if r.Name != "test" || r.Expression != tt.expr || len(r.Roles) != len(tt.roles) {
+				t.Fatalf("ParseRule(%q) = %+v", tt.in, r)
+			}
+			for i := range tt.roles {
+				if r.Roles[i] != tt.roles[i] {
+					t.Errorf("ParseRule(%q) role %d = %q, want %q", tt.in, i, r.Roles[i], tt.roles[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    Rule
+		wantErr bool
+	}{
+		{name: "valid", rule: Rule{Name: "gpu", Expression: "node.status.allocatable['nvidia.com/gpu'] > 0", Roles: []string{"gpu"}}},
+		{name: "syntax error", rule: Rule{Name: "bad", Expression: "node.metadata.name ==", Roles: []string{"x"}}, wantErr: true},
+		{name: "unknown variable", rule: Rule{Name: "bad", Expression: "pod.metadata.name == 'x'", Roles: []string{"x"}}, wantErr: true},
+		{name: "non-bool output", rule: Rule{Name: "bad", Expression: "'gpu'", Roles: []string{"x"}}, wantErr: true},
+		{name: "no roles", rule: Rule{Name: "bad", Expression: "true"}, wantErr: true},
+		{name: "no name", rule: Rule{Expression: "true", Roles: []string{"x"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRules(tt.rule)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules, err := compileRules([]Rule{
+		{Name: "gpu", Expression: "node.status.allocatable['nvidia.com/gpu'] > 0", Roles: []string{"gpu"}},
+		{Name: "tainted", Expression: "node.spec.taints.exists(t, t.key == 'dedicated')", Roles: []string{"dedicated", "isolated"}},
+		{Name: "big", Expression: "node.status.allocatable.memory > 64 * 1024 * 1024 * 1024", Roles: []string{"big"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to compile rules: %v", err)
+	}
+
+	res := evaluateRules(getTestGPUNode("n1", 2), rules, logger.GetTestLogger())
+	want := []Resolution{
+		{Role: "gpu", Source: "rule:gpu"},
+		{Role: "dedicated", Source: "rule:tainted"},
+		{Role: "isolated", Source: "rule:tainted"},
+	}
+	if len(res) != len(want) {
+		t.Fatalf("expected %v, got %v", want, res)
+	}
+	for i := range want {
+		if res[i] != want[i] {
+			t.Errorf("resolution %d = %+v, want %+v", i, res[i], want[i])
+		}
+	}
+
+	// Missing keys are treated as a non-match
+	if res := evaluateRules(getTestNode("n2", nil), rules, logger.GetTestLogger()); len(res) != 0 {
+		t.Errorf("expected no resolutions, got %v", res)
+	}
+}
+
+func TestEnsureRole_Rules(t *testing.T) {
+	var gotPatchData []byte
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		gotPatchData = data
+		return nil, nil
+	}
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(), WithRules(Rule{
+		Name:       "gpu",
+		Expression: "node.status.allocatable['nvidia.com/gpu'] > 0",
+		Roles:      []string{"gpu"},
+	}))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	h.EnsureRole(context.Background(), getTestGPUNode("n1", 1))
+
+	labels := getAppliedLabels(t, gotPatchData)
+	if v, ok := labels[rolePrefix+"gpu"]; !ok || v != "" {
+		t.Errorf("expected gpu role to be set, got %v", labels)
+	}
+}
+
+func TestNewCacheResourceHandler_InvalidRule(t *testing.T) {
+	_, err := NewCacheResourceHandler(newTestPatcher(nil, nil), logger.GetTestLogger(),
+		WithRules(Rule{Name: "bad", Expression: "node.", Roles: []string{"x"}}),
+	)
+	if err == nil {
+		t.Error("expected error for invalid rule")
+	}
+}
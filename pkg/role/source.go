@@ -0,0 +1,84 @@
+package role
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SourceMode defines how multiple source labels are resolved into roles.
+type SourceMode string
+
+const (
+	// SourceModeFirst uses only the first source label (in priority order) present on the node.
+	SourceModeFirst SourceMode = "first"
+	// SourceModeAll uses every source label present on the node.
+	SourceModeAll SourceMode = "all"
+)
+
+// ParseSourceMode converts a string into a SourceMode, defaulting to SourceModeFirst when empty.
+func ParseSourceMode(s string) (SourceMode, error) {
+	switch SourceMode(strings.TrimSpace(strings.ToLower(s))) {
+	case "", SourceModeFirst:
+		return SourceModeFirst, nil
+	case SourceModeAll:
+		return SourceModeAll, nil
+	default:
+		return "", fmt.Errorf("invalid source mode %q, must be one of: %s, %s", s, SourceModeFirst, SourceModeAll)
+	}
+}
+
+// Resolution is a single role resolved for a node along with the source that produced it.
+type Resolution struct {
+	// Role is the resolved role name.
+	Role string
+	// Source is the label key (or rule) that produced the role.
+	Source string
+	// Value is the source label value the role was transformed from, if any.
+	Value string
+}
+
+// ValidateSeparator checks that the separator can appear in a label value, which may only contain
+// alphanumerics, '-', '_' and '.'; the API server rejects any other character.
+func ValidateSeparator(sep string) error {
+	for _, c := range sep {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' {
+			return fmt.Errorf("invalid separator %q, label values may only contain alphanumerics, '-', '_' and '.'", sep)
+		}
+	}
+	return nil
+}
+
+// resolveSources returns the roles resolved from the node labels using the configured sources and mode.
+// With a separator, each label value is split into several roles.
+func resolveSources(n *corev1.Node, sources []string, mode SourceMode, separator string) []Resolution {
+	var res []Resolution
+	for _, key := range sources {
+		val, ok := n.Labels[key]
+		if !ok || val == "" {
+			continue
+		}
+		for _, v := range splitValue(val, separator) {
+			res = append(res, Resolution{Role: v, Source: key, Value: v})
+		}
+		if mode == SourceModeFirst {
+			break
+		}
+	}
+	return res
+}
+
+// splitValue splits the value by the separator, dropping empty parts.
+func splitValue(val, separator string) []string {
+	if separator == "" {
+		return []string{val}
+	}
+	var vals []string
+	for _, v := range strings.Split(val, separator) {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
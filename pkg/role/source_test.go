@@ -0,0 +1,91 @@
+package role
+
+import (
+	"testing"
+)
+
+func TestParseSourceMode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SourceMode
+		wantErr bool
+	}{
+		{in: "", want: SourceModeFirst},
+		{in: "first", want: SourceModeFirst},
+		{in: " ALL ", want: SourceModeAll},
+		{in: "some", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSourceMode(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSourceMode(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSourceMode(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveSources(t *testing.T) {
+	node := getTestNode("n1", map[string]string{
+		"eks.amazonaws.com/nodegroup": "workers",
+		"karpenter.sh/nodepool":       "gpu",
+		"empty":                       "",
+	})
+	sources := []string{"nodeGroup", "empty", "karpenter.sh/nodepool", "eks.amazonaws.com/nodegroup"}
+
+	first := resolveSources(node, sources, SourceModeFirst, "")
+	if len(first) != 1 || first[0].Role != "gpu" || first[0].Source != "karpenter.sh/nodepool" {
+		t.Errorf("unexpected first mode resolution: %+v", first)
+	}
+
+	all := resolveSources(node, sources, SourceModeAll, "")
+	if len(all) != 2 {
+		t.Fatalf("expected 2 resolutions, got %+v", all)
+	}
+	if all[0].Source != "karpenter.sh/nodepool" || all[1].Source != "eks.amazonaws.com/nodegroup" {
+		t.Errorf("resolutions not in priority order: %+v", all)
+	}
+
+	if none := resolveSources(getTestNode("n2", nil), sources, SourceModeAll, ""); len(none) != 0 {
+		t.Errorf("expected no resolutions, got %+v", none)
+	}
+}
+
+func TestResolveSources_Separator(t *testing.T) {
+	node := getTestNode("n1", map[string]string{
+		"nodeGroup": "gpu__ingress",
+		"pool":      "spot",
+	})
+
+	got := resolveSources(node, []string{"nodeGroup", "pool"}, SourceModeFirst, "_")
+	if len(got) != 2 || got[0].Role != "gpu" || got[1].Role != "ingress" || got[1].Source != "nodeGroup" {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+
+	got = resolveSources(node, []string{"nodeGroup", "pool"}, SourceModeAll, "_")
+	if len(got) != 3 || got[2].Role != "spot" {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+
+	// Without a separator, the value is a single role
+	got = resolveSources(node, []string{"nodeGroup"}, SourceModeFirst, "")
+	if len(got) != 1 || got[0].Role != "gpu__ingress" {
+		t.Errorf("unexpected resolution: %+v", got)
+	}
+}
+
+func TestValidateSeparator(t *testing.T) {
+	for _, sep := range []string{"", "_", ".", "-", "__"} {
+		if err := ValidateSeparator(sep); err != nil {
+			t.Errorf("unexpected error for %q: %v", sep, err)
+		}
+	}
+	for _, sep := range []string{",", " ", ";", "/"} {
+		if err := ValidateSeparator(sep); err == nil {
+			t.Errorf("expected error for %q", sep)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package role
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// startupGracePeriodDefault is how long a node may keep the startup taint before it is reported.
+	startupGracePeriodDefault = 5 * time.Minute
+
+	// eventReasonStartupTaintPending is the reason of the Node event recorded for a node that keeps the startup taint
+	eventReasonStartupTaintPending = "StartupTaintPending"
+
+	// reasons a node keeps the startup taint
+	pendingNoRole     = "no role resolved"
+	pendingRejected   = "a resolved role was rejected"
+	pendingApplyError = "role labels failed to apply"
+)
+
+var (
+	startupRemovedCounter = metric.NewCounter("node_role_startup_taint_removed_total", "Total number of startup taints removed once the node roles were applied")
+	startupPendingGauge   = metric.NewGauge("node_role_startup_taint_pending_seconds", "Age of a node that keeps the startup taint past the grace period", "node")
+)
+
+// StartupTaint is the taint nodes register with (e.g. kubelet --register-with-taints) to keep
+// workloads off until their roles are applied. The zero value disables it.
+type StartupTaint struct {
+	Key    string
+	Effect corev1.TaintEffect
+	// GracePeriod is how long a node may keep the taint before it is reported as pending,
+	// 5m when zero.
+	GracePeriod time.Duration
+}
+
+// Validate checks the taint key and effect when the startup taint is enabled.
+func (s StartupTaint) Validate() error {
+	if s.Key == "" {
+		return nil
+	}
+	if errs := validation.IsQualifiedName(s.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid startup taint key %q: %s", s.Key, strings.Join(errs, "; "))
+	}
+	switch s.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+	default:
+		return fmt.Errorf("invalid startup taint effect %q, must be one of: %s, %s, %s", s.Effect,
+			corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute)
+	}
+	if s.GracePeriod < 0 {
+		return fmt.Errorf("startup taint grace period must not be negative")
+	}
+	return nil
+}
+
+// matches reports whether the taint is the startup taint.
+func (s StartupTaint) matches(t corev1.Taint) bool {
+	return s.Key != "" && t.Key == s.Key && t.Effect == s.Effect
+}
+
+// on reports whether the node has the startup taint.
+func (s StartupTaint) on(n *corev1.Node) bool {
+	for _, t := range n.Spec.Taints {
+		if s.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// reportStartupPending reports a node that keeps the startup taint past the grace period
+// with a metric and a Node event.
+func (h *CacheResourceHandler) reportStartupPending(n *corev1.Node, reason string) {
+	if !h.startup.on(n) {
+		return
+	}
+	grace := h.startup.GracePeriod
+	if grace == 0 {
+		grace = startupGracePeriodDefault
+	}
+	age := time.Since(n.CreationTimestamp.Time)
+	if age < grace {
+		return
+	}
+
+	startupPendingGauge.Set(age.Seconds(), n.Name)
+	h.logger.Warn("node keeps the startup taint",
+		zap.String("node", n.Name),
+		zap.String("taint", taintID(h.startup.Key, h.startup.Effect)),
+		zap.String("reason", reason),
+		zap.Duration("age", age),
+	)
+	h.eventf(n, corev1.EventTypeWarning, eventReasonStartupTaintPending,
+		"Node keeps the startup taint %s after %s: %s", taintID(h.startup.Key, h.startup.Effect), age.Round(time.Second), reason)
+}
@@ -0,0 +1,119 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestStartupTaint_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       StartupTaint
+		wantErr bool
+	}{
+		{name: "disabled"},
+		{name: "valid", s: StartupTaint{Key: "rolesetter.io/unlabeled", Effect: corev1.TaintEffectNoSchedule}},
+		{name: "invalid key", s: StartupTaint{Key: "bad key", Effect: corev1.TaintEffectNoSchedule}, wantErr: true},
+		{name: "missing effect", s: StartupTaint{Key: "rolesetter.io/unlabeled"}, wantErr: true},
+		{name: "negative grace", s: StartupTaint{Key: "a", Effect: corev1.TaintEffectNoExecute, GracePeriod: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEnsureRole_StartupTaint(t *testing.T) {
+	startup := StartupTaint{Key: "rolesetter.io/unlabeled", Effect: corev1.TaintEffectNoSchedule}
+	taint := corev1.Taint{Key: startup.Key, Effect: startup.Effect}
+	other := corev1.Taint{Key: "node.kubernetes.io/not-ready", Effect: corev1.TaintEffectNoSchedule}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantRemoved bool
+		wantEvent   string
+	}{
+		{name: "removed once roles are applied", labels: map[string]string{"nodeGroup": "gpu"}, wantRemoved: true},
+		{name: "kept without roles", labels: map[string]string{}, wantEvent: pendingNoRole},
+		{name: "kept with a rejected role", labels: map[string]string{"nodeGroup": "gpu_control-plane"}, wantEvent: pendingRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := getTestNode("n1", tt.labels)
+			node.ResourceVersion = "1"
+			node.CreationTimestamp = metav1.NewTime(time.Now().Add(-10 * time.Minute))
+			node.Spec.Taints = []corev1.Taint{other, taint}
+
+			var taints *taintsPatch
+			patcher := func(_ context.Context, _ string, pt types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				if pt == types.ApplyPatchType {
+					// The apply returns the node with a new resourceVersion
+					patched := node.DeepCopy()
+					patched.ResourceVersion = "2"
+					return patched, nil
+				}
+				taints = &taintsPatch{}
+				if err := json.Unmarshal(data, taints); err != nil {
+					t.Fatalf("failed to decode taints patch: %v", err)
+				}
+				return nil, nil
+			}
+			recorder := record.NewFakeRecorder(10)
+
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithSeparator("_"),
+				WithStartupTaint(startup),
+				WithRecorder(recorder),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.wantRemoved {
+				if taints == nil {
+					t.Fatal("expected a taints patch")
+				}
+				if taints.Metadata.ResourceVersion != "2" {
+					t.Errorf("expected the taints patch to use the applied node's resourceVersion, got %q", taints.Metadata.ResourceVersion)
+				}
+				if len(taints.Spec.Taints) != 1 || !taints.Spec.Taints[0].MatchTaint(&other) {
+					t.Errorf("expected only the other taint to be kept, got %v", taints.Spec.Taints)
+				}
+				return
+			}
+
+			if taints != nil {
+				t.Errorf("expected no taints patch, got %+v", taints)
+			}
+			found := false
+			for len(recorder.Events) > 0 {
+				e := <-recorder.Events
+				if strings.Contains(e, eventReasonStartupTaintPending) && strings.Contains(e, tt.wantEvent) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a %s event with reason %q", eventReasonStartupTaintPending, tt.wantEvent)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// StatusAnnotation is the node annotation holding the JSON NodeStatus of the last reconcile.
+	StatusAnnotation = "rolesetter.io/status"
+
+	// statusHistoryDefault is the number of previous transitions kept when none is configured.
+	statusHistoryDefault = 5
+	// statusHistoryMax bounds the history so the annotation stays small.
+	statusHistoryMax = 20
+)
+
+// Status configures the status annotation recorded on each node. The zero value disables it.
+type Status struct {
+	Enabled bool
+	// History is the number of previous transitions kept, 5 when zero.
+	History int
+}
+
+// Validate checks that the history is within bounds.
+func (s Status) Validate() error {
+	if s.History < 0 || s.History > statusHistoryMax {
+		return fmt.Errorf("status history must be between 0 and %d", statusHistoryMax)
+	}
+	return nil
+}
+
+// history returns the number of previous transitions kept.
+func (s Status) history() int {
+	if s.History == 0 {
+		return statusHistoryDefault
+	}
+	return s.History
+}
+
+// NodeStatus is the value of the status annotation: what the controller last did on the node,
+// and the previous transitions, newest first.
+type NodeStatus struct {
+	StatusEntry
+	History []StatusEntry `json:"history,omitempty"`
+}
+
+// StatusEntry is the outcome of a reconcile. A new entry is recorded when the roles or the error change.
+type StatusEntry struct {
+	// Roles are the roles applied to the node.
+	Roles []StatusRole `json:"roles"`
+	// Generation is the config generation the node was reconciled with.
+	Generation int64 `json:"generation"`
+	// Time is when the roles or the error last changed.
+	Time time.Time `json:"time"`
+	// Error is the error of the last reconcile, if it failed.
+	Error string `json:"error,omitempty"`
+}
+
+// StatusRole is a role applied to the node along with the source it was resolved from.
+// Roles kept from earlier reconciles (without replace) have no source.
+type StatusRole struct {
+	Role   string `json:"role"`
+	Source string `json:"source,omitempty"`
+	Value  string `json:"value,omitempty"`
+}
+
+// sameOutcome reports whether the two entries have the same roles and error.
+func (e StatusEntry) sameOutcome(o StatusEntry) bool {
+	return e.Error == o.Error && slices.Equal(e.Roles, o.Roles)
+}
+
+// nodeStatus returns the status recorded on the node, if any. A malformed annotation is ignored.
+func nodeStatus(n *corev1.Node) (NodeStatus, bool) {
+	var st NodeStatus
+	v, ok := n.Annotations[StatusAnnotation]
+	if !ok || json.Unmarshal([]byte(v), &st) != nil {
+		return NodeStatus{}, false
+	}
+	return st, true
+}
+
+// statusRoles returns the roles for the status: the desired ones with their source,
+// followed by the roles of the other applied labels.
+func (h *CacheResourceHandler) statusRoles(desired []desiredRole, labels map[string]string) []StatusRole {
+	sources := make(map[string]Resolution, len(desired))
+	for _, r := range desired {
+		if _, ok := sources[r.Role]; !ok {
+			sources[r.Role] = r.Resolution
+		}
+	}
+	roles := h.rolesOf(desired, labels)
+	res := make([]StatusRole, 0, len(roles))
+	for _, r := range roles {
+		s := sources[r]
+		res = append(res, StatusRole{Role: r, Source: s.Source, Value: s.Value})
+	}
+	return res
+}
+
+// recordStatus writes the outcome of the reconcile to the status annotation of the node.
+// The annotation is only patched when the roles, the error or the config generation change,
+// and a change of the roles or the error moves the previous entry to the bounded history.
+// Failing to record the status is logged and doesn't fail the reconcile.
+func (h *CacheResourceHandler) recordStatus(ctx context.Context, n *corev1.Node, roles []StatusRole, err error) {
+	if !h.status.Enabled || h.dryRun {
+		return
+	}
+	// A stale resourceVersion is retried with the latest node, it is not an outcome to record
+	if err != nil && apierrors.IsConflict(err) && !IsPermanent(err) {
+		return
+	}
+
+	cur := StatusEntry{Roles: roles, Generation: h.generation}
+	if cur.Roles == nil {
+		cur.Roles = []StatusRole{}
+	}
+	if err != nil {
+		cur.Error = err.Error()
+	}
+
+	prev, ok := nodeStatus(n)
+	var next NodeStatus
+	switch {
+	case !ok && len(roles) == 0 && err == nil:
+		// Nodes the controller never acted on are left alone
+		return
+	case ok && prev.sameOutcome(cur):
+		if prev.Generation == cur.Generation {
+			return
+		}
+		next = prev
+		next.Generation = cur.Generation
+	default:
+		cur.Time = time.Now().UTC().Truncate(time.Second)
+		next = NodeStatus{StatusEntry: cur}
+		if ok {
+			next.History = append([]StatusEntry{prev.StatusEntry}, prev.History...)
+		}
+	}
+	if len(next.History) > h.status.history() {
+		next.History = next.History[:h.status.history()]
+	}
+
+	if err := h.patchStatus(ctx, n.Name, next); err != nil {
+		h.logger.Warn("failed to record node status",
+			zap.String("node", n.Name),
+			zap.Error(err),
+		)
+	}
+}
+
+// patchStatus writes the status annotation with a merge patch.
+func (h *CacheResourceHandler) patchStatus(ctx context.Context, name string, st NodeStatus) error {
+	value, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("failed to encode status of node %s: %w", name, err)
+	}
+	data, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"annotations": map[string]string{StatusAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create status patch for node %s: %w", name, err)
+	}
+
+	patchCtx, cancel := context.WithTimeout(ctx, patchTimeout)
+	defer cancel()
+
+	if _, err := h.patcher(patchCtx, name, types.MergePatchType, data, metav1.PatchOptions{FieldManager: h.fieldManager}); err != nil {
+		return fmt.Errorf("failed to patch status of node %s: %w", name, err)
+	}
+	return nil
+}
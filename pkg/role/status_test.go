@@ -0,0 +1,183 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// withStatus records the status annotation on the node.
+func withStatus(n *corev1.Node, st NodeStatus) *corev1.Node {
+	raw, _ := json.Marshal(st)
+	if n.Annotations == nil {
+		n.Annotations = map[string]string{}
+	}
+	n.Annotations[StatusAnnotation] = string(raw)
+	return n
+}
+
+func TestStatus_Validate(t *testing.T) {
+	for _, s := range []Status{{}, {Enabled: true, History: statusHistoryMax}} {
+		if err := s.Validate(); err != nil {
+			t.Errorf("unexpected error for %+v: %v", s, err)
+		}
+	}
+	for _, s := range []Status{{History: -1}, {History: statusHistoryMax + 1}} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("expected error for %+v", s)
+		}
+	}
+}
+
+func TestEnsureRole_Status(t *testing.T) {
+	then := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	gpu := []StatusRole{{Role: "gpu", Source: "nodeGroup", Value: "gpu"}}
+	old := StatusEntry{Roles: []StatusRole{{Role: "cpu", Source: "nodeGroup", Value: "cpu"}}, Generation: 2, Time: then}
+
+	tests := []struct {
+		name        string
+		node        *corev1.Node
+		applyErr    error
+		history     int
+		wantPatch   bool
+		wantRoles   []StatusRole
+		wantError   bool
+		wantTime    time.Time
+		wantHistory int
+	}{
+		{
+			name:      "first reconcile",
+			node:      getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+			wantPatch: true,
+			wantRoles: gpu,
+		},
+		{
+			name: "unchanged",
+			node: withStatus(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				NodeStatus{StatusEntry: StatusEntry{Roles: gpu, Generation: 3, Time: then}}),
+		},
+		{
+			name: "new generation keeps the entry",
+			node: withStatus(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				NodeStatus{StatusEntry: StatusEntry{Roles: gpu, Generation: 2, Time: then}}),
+			wantPatch: true,
+			wantRoles: gpu,
+			wantTime:  then,
+		},
+		{
+			name:        "role change moves the entry to the history",
+			node:        withStatus(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}), NodeStatus{StatusEntry: old}),
+			wantPatch:   true,
+			wantRoles:   gpu,
+			wantHistory: 1,
+		},
+		{
+			name:        "failure keeps the roles",
+			node:        withStatus(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}), NodeStatus{StatusEntry: old}),
+			applyErr:    errors.New("boom"),
+			wantPatch:   true,
+			wantRoles:   old.Roles,
+			wantError:   true,
+			wantHistory: 1,
+		},
+		{
+			name: "history is bounded",
+			node: withStatus(getTestNode("n1", map[string]string{"nodeGroup": "gpu"}),
+				NodeStatus{StatusEntry: old, History: []StatusEntry{old, old}}),
+			history:     2,
+			wantPatch:   true,
+			wantRoles:   gpu,
+			wantHistory: 2,
+		},
+		{
+			name: "node never acted on",
+			node: getTestNode("n1", map[string]string{}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *NodeStatus
+			patcher := func(_ context.Context, _ string, pt types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				if pt == types.ApplyPatchType {
+					return nil, tt.applyErr
+				}
+				var n corev1.Node
+				if err := json.Unmarshal(data, &n); err != nil {
+					t.Fatalf("failed to decode patch: %v", err)
+				}
+				got = &NodeStatus{}
+				if err := json.Unmarshal([]byte(n.Annotations[StatusAnnotation]), got); err != nil {
+					t.Fatalf("failed to decode status: %v", err)
+				}
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithStatus(Status{Enabled: true, History: tt.history}),
+				WithGeneration(3),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			if err := h.EnsureRole(context.Background(), tt.node); (err != nil) != (tt.applyErr != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !tt.wantPatch {
+				if got != nil {
+					t.Errorf("expected no status patch, got %+v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected a status patch")
+			}
+			if len(got.Roles) != len(tt.wantRoles) || (len(got.Roles) > 0 && got.Roles[0] != tt.wantRoles[0]) {
+				t.Errorf("expected roles %+v, got %+v", tt.wantRoles, got.Roles)
+			}
+			if got.Generation != 3 {
+				t.Errorf("expected generation 3, got %d", got.Generation)
+			}
+			if (got.Error != "") != tt.wantError {
+				t.Errorf("expected error %v, got %q", tt.wantError, got.Error)
+			}
+			if !tt.wantTime.IsZero() && !got.Time.Equal(tt.wantTime) {
+				t.Errorf("expected time %v, got %v", tt.wantTime, got.Time)
+			}
+			if got.Time.IsZero() {
+				t.Error("expected time to be set")
+			}
+			if len(got.History) != tt.wantHistory {
+				t.Errorf("expected %d history entries, got %d", tt.wantHistory, len(got.History))
+			}
+		})
+	}
+}
+
+func TestEnsureRole_StatusDisabled(t *testing.T) {
+	patches := 0
+	patcher := func(_ context.Context, _ string, pt types.PatchType, _ []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		if pt == types.MergePatchType {
+			patches++
+		}
+		return nil, nil
+	}
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(), WithSources("nodeGroup"))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	if err := h.EnsureRole(context.Background(), getTestNode("n1", map[string]string{"nodeGroup": "gpu"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if patches != 0 {
+		t.Errorf("expected no status patch, got %d", patches)
+	}
+}
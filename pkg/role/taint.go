@@ -0,0 +1,277 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TaintsAnnotation records the taints the handler manages on a node, as comma-separated
+// <key>:<effect> pairs, so they are kept apart from taints owned by other components.
+const TaintsAnnotation = "rolesetter.io/taints"
+
+var (
+	taintAddedCounter   = metric.NewCounter("node_role_taint_added_total", "Total number of role taints added to nodes", "key", "effect")
+	taintRemovedCounter = metric.NewCounter("node_role_taint_removed_total", "Total number of role taints removed from nodes", "key", "effect")
+)
+
+// Taint is a taint applied to the nodes with a role.
+type Taint struct {
+	Key    string
+	Value  string
+	Effect corev1.TaintEffect
+}
+
+// ValidateTaints checks the taints of every role, reporting all errors found.
+func ValidateTaints(taints map[string][]Taint) error {
+	var errs []error
+	for _, r := range sortedKeys(taints) {
+		for _, t := range taints[r] {
+			if err := t.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("role %s: %w", r, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t Taint) validate() error {
+	if errs := validation.IsQualifiedName(t.Key); len(errs) > 0 {
+		return fmt.Errorf("invalid taint key %q: %s", t.Key, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(t.Value); len(errs) > 0 {
+		return fmt.Errorf("invalid taint value %q for %s: %s", t.Value, t.Key, strings.Join(errs, "; "))
+	}
+	switch t.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		return nil
+	default:
+		return fmt.Errorf("invalid taint effect %q for %s, must be one of: %s, %s, %s", t.Effect, t.Key,
+			corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute)
+	}
+}
+
+// taintID identifies a taint on a node; a node has at most one taint per key and effect.
+func taintID(key string, effect corev1.TaintEffect) string {
+	return key + ":" + string(effect)
+}
+
+// managedTaintIDs parses the managed taints annotation value, keeping only the taints a configured role declares.
+// Anyone who can annotate the node can edit the annotation, so it must not make other taints removable.
+func (h *CacheResourceHandler) managedTaintIDs(s string) map[string]bool {
+	declared := map[string]bool{}
+	for _, ts := range h.taints {
+		for _, t := range ts {
+			declared[taintID(t.Key, t.Effect)] = true
+		}
+	}
+	ids := map[string]bool{}
+	for _, id := range splitValue(s, ",") {
+		if declared[id] {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// desiredTaints returns the taints of the roles in priority order. A taint with the same key
+// and effect as one of a role earlier in the list is left out.
+func (h *CacheResourceHandler) desiredTaints(roles []string) []corev1.Taint {
+	var res []corev1.Taint
+	seen := map[string]bool{}
+	for _, r := range roles {
+		for _, t := range h.taints[r] {
+			id := taintID(t.Key, t.Effect)
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			res = append(res, corev1.Taint{Key: t.Key, Value: t.Value, Effect: t.Effect})
+		}
+	}
+	return res
+}
+
+// ensureTaints brings the taints the handler manages on the node to the ones of the roles.
+// Taints set by other components are never changed, even when a role declares the same key and effect,
+// and neither are taints in the managed taints annotation that no role declares, except for the startup taint, which is removed unless a pending reason is given.
+// Taints are an atomic list, so the full list is written with a merge patch guarded by the node's
+// resourceVersion, along with the annotation recording the managed taints.
+func (h *CacheResourceHandler) ensureTaints(ctx context.Context, n *corev1.Node, roles []string, pending string) error {
+	startup := h.startup.on(n)
+	if len(h.taints) == 0 && n.Annotations[TaintsAnnotation] == "" && !startup {
+		return nil
+	}
+	if startup && pending != "" {
+		h.reportStartupPending(n, pending)
+	}
+
+	managed := h.managedTaintIDs(n.Annotations[TaintsAnnotation])
+	want := h.desiredTaints(roles)
+	wanted := make(map[string]corev1.Taint, len(want))
+	for _, t := range want {
+		wanted[taintID(t.Key, t.Effect)] = t
+	}
+
+	next := make([]corev1.Taint, 0, len(n.Spec.Taints)+len(want))
+	nextManaged := map[string]bool{}
+	onNode := map[string]bool{}
+	var added, removed []corev1.Taint
+	for _, t := range n.Spec.Taints {
+		id := taintID(t.Key, t.Effect)
+		onNode[id] = true
+		w, ok := wanted[id]
+		switch {
+		case h.startup.matches(t) && pending == "":
+			removed = append(removed, t)
+		case !managed[id]:
+			next = append(next, t)
+			if ok && w.Value != t.Value {
+				h.logger.Warn("role taint is set by another component, leaving it unchanged",
+					zap.String("node", n.Name),
+					zap.String("taint", id),
+					zap.String("value", t.Value),
+					zap.String("want", w.Value),
+				)
+			}
+		case ok:
+			t.Value = w.Value
+			next = append(next, t)
+			nextManaged[id] = true
+		default:
+			removed = append(removed, t)
+		}
+	}
+	for _, t := range want {
+		id := taintID(t.Key, t.Effect)
+		if onNode[id] {
+			continue
+		}
+		next = append(next, t)
+		nextManaged[id] = true
+		added = append(added, t)
+	}
+
+	annotation := strings.Join(sortedKeys(nextManaged), ",")
+	if equality.Semantic.DeepEqual(next, n.Spec.Taints) && annotation == n.Annotations[TaintsAnnotation] {
+		return nil
+	}
+
+	if err := h.patchTaints(ctx, n, next, annotation); err != nil {
+		h.logger.Error("patch node taints failed",
+			zap.String("node", n.Name),
+			zap.Error(err),
+		)
+		// A stale resourceVersion is retried with the latest node, it is not a failure to report
+		if !apierrors.IsConflict(err) {
+			h.eventf(n, corev1.EventTypeWarning, eventReasonPatchFailed, "Failed to patch role taints: %v", err)
+		}
+		return err
+	}
+
+	msg := "node role taints patched successfully"
+	if h.dryRun {
+		msg = "planned role taint changes (dry run)"
+	}
+	h.logger.Info(msg,
+		zap.String("node", n.Name),
+		zap.Strings("add", taintStrings(added)),
+		zap.Strings("remove", taintStrings(removed)),
+	)
+	if h.dryRun {
+		return nil
+	}
+	for _, t := range added {
+		taintAddedCounter.Increment(t.Key, string(t.Effect))
+	}
+	for _, t := range removed {
+		if h.startup.matches(t) {
+			startupRemovedCounter.Increment()
+			startupPendingGauge.Delete(n.Name)
+			continue
+		}
+		taintRemovedCounter.Increment(t.Key, string(t.Effect))
+	}
+	return nil
+}
+
+// patchTaints writes the full taint list and the managed taints annotation with a merge patch.
+// A stale resourceVersion fails with a conflict, which is retried with the latest node.
+func (h *CacheResourceHandler) patchTaints(ctx context.Context, n *corev1.Node, taints []corev1.Taint, annotation string) error {
+	var value any
+	if annotation != "" {
+		value = annotation
+	}
+	data, err := json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			"resourceVersion": n.ResourceVersion,
+			"annotations":     map[string]any{TaintsAnnotation: value},
+		},
+		"spec": map[string]any{
+			"taints": taints,
+		},
+	})
+	if err != nil {
+		return &permanentError{err: fmt.Errorf("failed to create taints patch for node %s: %w", n.Name, err)}
+	}
+
+	patchCtx, cancel := context.WithTimeout(ctx, patchTimeout)
+	defer cancel()
+
+	opts := metav1.PatchOptions{FieldManager: h.fieldManager}
+	if h.dryRun {
+		opts.DryRun = []string{metav1.DryRunAll}
+	}
+	if _, err := h.patcher(patchCtx, n.Name, types.MergePatchType, data, opts); err != nil {
+		if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsInvalid(err) {
+			return &permanentError{err: fmt.Errorf("non-retryable error patching taints of node %s: %w", n.Name, err)}
+		}
+		return fmt.Errorf("failed to patch taints of node %s: %w", n.Name, err)
+	}
+	return nil
+}
+
+// rolesOf returns the desired roles in priority order, followed by the roles of the other
+// labels, e.g. owned labels kept without replace, in sorted order.
+func (h *CacheResourceHandler) rolesOf(desired []desiredRole, labels map[string]string) []string {
+	seen := map[string]bool{}
+	var roles []string
+	for _, r := range desired {
+		if !seen[r.Role] {
+			seen[r.Role] = true
+			roles = append(roles, r.Role)
+		}
+	}
+	for _, k := range sortedKeys(labels) {
+		if !isOutput(h.compiledOutputs, k) {
+			continue
+		}
+		if r := roleOf(h.compiledOutputs, k, labels[k]); !seen[r] {
+			seen[r] = true
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
+
+// taintStrings formats the taints as <key>[=<value>]:<effect>.
+func taintStrings(taints []corev1.Taint) []string {
+	res := make([]string, 0, len(taints))
+	for _, t := range taints {
+		res = append(res, t.ToString())
+	}
+	sort.Strings(res)
+	return res
+}
@@ -0,0 +1,181 @@
+package role
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// taintsPatch is the merge patch written by patchTaints.
+type taintsPatch struct {
+	Metadata struct {
+		ResourceVersion string             `json:"resourceVersion"`
+		Annotations     map[string]*string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Taints []corev1.Taint `json:"taints"`
+	} `json:"spec"`
+}
+
+func TestValidateTaints(t *testing.T) {
+	valid := map[string][]Taint{"gpu": {{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}}}
+	if err := ValidateTaints(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tests := map[string]Taint{
+		"key":    {Key: "bad key", Effect: corev1.TaintEffectNoSchedule},
+		"value":  {Key: "dedicated", Value: "-gpu", Effect: corev1.TaintEffectNoSchedule},
+		"effect": {Key: "dedicated", Effect: "Sometimes"},
+	}
+	for name, taint := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateTaints(map[string][]Taint{"gpu": {taint}}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestEnsureRole_Taints(t *testing.T) {
+	dedicated := func(v string) Taint {
+		return Taint{Key: "dedicated", Value: v, Effect: corev1.TaintEffectNoSchedule}
+	}
+	other := corev1.Taint{Key: "node.kubernetes.io/unreachable", Effect: corev1.TaintEffectNoExecute}
+	quarantine := corev1.Taint{Key: "admin/quarantine", Effect: corev1.TaintEffectNoExecute}
+
+	tests := []struct {
+		name      string
+		labels    map[string]string
+		taints    []corev1.Taint
+		managed   string
+		want      []corev1.Taint
+		wantAnn   *string
+		wantPatch bool
+	}{
+		{
+			name:      "add keeps other taints",
+			labels:    map[string]string{"nodeGroup": "gpu_ingress"},
+			taints:    []corev1.Taint{other},
+			want:      []corev1.Taint{other, {Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			wantAnn:   strPtr("dedicated:NoSchedule"),
+			wantPatch: true,
+		},
+		{
+			name:    "in sync",
+			labels:  map[string]string{"nodeGroup": "gpu"},
+			taints:  []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			managed: "dedicated:NoSchedule",
+		},
+		{
+			name:      "update value",
+			labels:    map[string]string{"nodeGroup": "ingress"},
+			taints:    []corev1.Taint{{Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			managed:   "dedicated:NoSchedule",
+			want:      []corev1.Taint{{Key: "dedicated", Value: "ingress", Effect: corev1.TaintEffectNoSchedule}},
+			wantAnn:   strPtr("dedicated:NoSchedule"),
+			wantPatch: true,
+		},
+		{
+			name:      "remove when role is gone",
+			labels:    map[string]string{},
+			taints:    []corev1.Taint{other, {Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			managed:   "dedicated:NoSchedule",
+			want:      []corev1.Taint{other},
+			wantPatch: true,
+		},
+		{
+			name:      "annotated taint no role declares is left unchanged",
+			labels:    map[string]string{},
+			taints:    []corev1.Taint{quarantine},
+			managed:   "admin/quarantine:NoExecute",
+			want:      []corev1.Taint{quarantine},
+			wantPatch: true,
+		},
+		{
+			name:      "annotated taint no role declares is kept next to role taints",
+			labels:    map[string]string{"nodeGroup": "gpu"},
+			taints:    []corev1.Taint{quarantine, {Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			managed:   "dedicated:NoSchedule,admin/quarantine:NoExecute",
+			want:      []corev1.Taint{quarantine, {Key: "dedicated", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}},
+			wantAnn:   strPtr("dedicated:NoSchedule"),
+			wantPatch: true,
+		},
+		{
+			name:   "taint set by another component is left unchanged",
+			labels: map[string]string{"nodeGroup": "gpu"},
+			taints: []corev1.Taint{{Key: "dedicated", Value: "batch", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *taintsPatch
+			patcher := func(_ context.Context, _ string, pt types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+				if pt != types.MergePatchType {
+					return nil, nil
+				}
+				got = &taintsPatch{}
+				if err := json.Unmarshal(data, got); err != nil {
+					t.Fatalf("failed to decode taints patch: %v", err)
+				}
+				return nil, nil
+			}
+			h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+				WithSources("nodeGroup"),
+				WithSeparator("_"),
+				WithTaints(map[string][]Taint{
+					"gpu":     {dedicated("gpu")},
+					"ingress": {dedicated("ingress")},
+				}),
+			)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+
+			node := getTestNode("n1", tt.labels)
+			node.ResourceVersion = "42"
+			node.Spec.Taints = tt.taints
+			if tt.managed != "" {
+				node.Annotations = map[string]string{TaintsAnnotation: tt.managed}
+			}
+			if err := h.EnsureRole(context.Background(), node); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !tt.wantPatch {
+				if got != nil {
+					t.Errorf("expected no taints patch, got %+v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected a taints patch")
+			}
+			if got.Metadata.ResourceVersion != "42" {
+				t.Errorf("expected the patch to be guarded by the resourceVersion, got %q", got.Metadata.ResourceVersion)
+			}
+			if len(got.Spec.Taints) != len(tt.want) {
+				t.Fatalf("expected taints %v, got %v", tt.want, got.Spec.Taints)
+			}
+			for i := range tt.want {
+				if !got.Spec.Taints[i].MatchTaint(&tt.want[i]) || got.Spec.Taints[i].Value != tt.want[i].Value {
+					t.Errorf("expected taint %v, got %v", tt.want[i], got.Spec.Taints[i])
+				}
+			}
+			ann := got.Metadata.Annotations[TaintsAnnotation]
+			if (ann == nil) != (tt.wantAnn == nil) || (ann != nil && *ann != *tt.wantAnn) {
+				t.Errorf("expected managed taints annotation %v, got %v", tt.wantAnn, ann)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
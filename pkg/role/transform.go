@@ -0,0 +1,106 @@
+package role
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Transform is a step of the chain that turns a source label value into a role.
+// Exactly one field must be set.
+type Transform struct {
+	// Regex replaces the value with its first capture group, or the whole match when the
+	// expression has no group. A value that doesn't match becomes empty.
+	Regex string
+	// Aliases replaces a value that exactly matches a key with its alias.
+	Aliases map[string]string
+	// StripPrefix removes the prefix from the value.
+	StripPrefix string
+	// StripSuffix removes the suffix from the value.
+	StripSuffix string
+}
+
+// compiledTransform is a Transform with its compiled regular expression, if any.
+type compiledTransform struct {
+	Transform
+	re *regexp.Regexp
+}
+
+// ValidateTransforms checks that every transform sets exactly one step and compiles its regex,
+// reporting all errors found.
+func ValidateTransforms(transforms ...Transform) error {
+	_, err := compileTransforms(transforms)
+	return err
+}
+
+// compileTransforms compiles the transforms, reporting all errors found.
+func compileTransforms(transforms []Transform) ([]compiledTransform, error) {
+	var errs []error
+	compiled := make([]compiledTransform, 0, len(transforms))
+	for i, t := range transforms {
+		steps := 0
+		for _, set := range []bool{t.Regex != "", len(t.Aliases) > 0, t.StripPrefix != "", t.StripSuffix != ""} {
+			if set {
+				steps++
+			}
+		}
+		if steps != 1 {
+			errs = append(errs, fmt.Errorf("transform %d: exactly one of regex, aliases, stripPrefix or stripSuffix must be specified", i+1))
+			continue
+		}
+
+		ct := compiledTransform{Transform: t}
+		if t.Regex != "" {
+			re, err := regexp.Compile(t.Regex)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("transform %d: invalid regex: %w", i+1, err))
+				continue
+			}
+			ct.re = re
+		}
+		compiled = append(compiled, ct)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return compiled, nil
+}
+
+// apply runs the step on the value.
+func (t compiledTransform) apply(v string) string {
+	switch {
+	case t.re != nil:
+		m := t.re.FindStringSubmatch(v)
+		if m == nil {
+			return ""
+		}
+		if len(m) > 1 {
+			return m[1]
+		}
+		return m[0]
+	case len(t.Aliases) > 0:
+		if alias, ok := t.Aliases[v]; ok {
+			return alias
+		}
+		return v
+	case t.StripPrefix != "":
+		return strings.TrimPrefix(v, t.StripPrefix)
+	default:
+		return strings.TrimSuffix(v, t.StripSuffix)
+	}
+}
+
+// transformValue runs the chain on the value, stopping once it is empty.
+// An empty result is replaced by the default role, if any.
+func transformValue(v string, transforms []compiledTransform, defaultRole string) string {
+	for _, t := range transforms {
+		if v = t.apply(v); v == "" {
+			break
+		}
+	}
+	if v == "" {
+		return defaultRole
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package role
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestValidateTransforms(t *testing.T) {
+	if err := ValidateTransforms(
+		Transform{Regex: "^eks-([a-z]+)-"},
+		Transform{Aliases: map[string]string{"a": "b"}},
+		Transform{StripPrefix: "eks-"},
+		Transform{StripSuffix: "-xyz"},
+	); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateTransforms(Transform{}); err == nil {
+		t.Error("expected error for empty transform")
+	}
+	if err := ValidateTransforms(Transform{StripPrefix: "a", StripSuffix: "b"}); err == nil {
+		t.Error("expected error for transform with multiple steps")
+	}
+	if err := ValidateTransforms(Transform{Regex: "("}); err == nil {
+		t.Error("expected error for invalid regex")
+	}
+}
+
+func TestTransformValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		transforms  []Transform
+		defaultRole string
+		in          string
+		want        string
+	}{
+		{name: "none", in: "gpu", want: "gpu"},
+		{name: "capture group", transforms: []Transform{{Regex: "^eks-([a-z]+)-"}}, in: "eks-gpu-a100-2024-09-xyz", want: "gpu"},
+		{name: "whole match", transforms: []Transform{{Regex: "[a-z]+[0-9]+"}}, in: "eks-gpu-a100", want: "a100"},
+		{name: "no match", transforms: []Transform{{Regex: "^aks-(.+)$"}}, in: "eks-gpu", want: ""},
+		{name: "no match default", transforms: []Transform{{Regex: "^aks-(.+)$"}}, defaultRole: "worker", in: "eks-gpu", want: "worker"},
+		{name: "alias", transforms: []Transform{{Aliases: map[string]string{"gpu-a100": "gpu"}}}, in: "gpu-a100", want: "gpu"},
+		{name: "alias miss", transforms: []Transform{{Aliases: map[string]string{"gpu-a100": "gpu"}}}, in: "cpu", want: "cpu"},
+		{name: "strip", transforms: []Transform{{StripPrefix: "eks-"}, {StripSuffix: "-xyz"}}, in: "eks-gpu-xyz", want: "gpu"},
+		{name: "strip to empty", transforms: []Transform{{StripPrefix: "eks-"}}, defaultRole: "worker", in: "eks-", want: "worker"},
+		{
+			name: "chain",
+			transforms: []Transform{
+				{StripPrefix: "eks-"},
+				{Regex: "^([a-z]+-[a-z0-9]+)"},
+				{Aliases: map[string]string{"gpu-a100": "gpu", "gpu-h100": "gpu"}},
+			},
+			in:   "eks-gpu-h100-2024-09-xyz",
+			want: "gpu",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compiled, err := compileTransforms(tt.transforms)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := transformValue(tt.in, compiled, tt.defaultRole); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnsureRole_Transforms(t *testing.T) {
+	var applied map[string]string
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		applied = getAppliedLabels(t, data)
+		return nil, nil
+	}
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("eks.amazonaws.com/nodegroup"),
+		WithRules(Rule{Name: "edge", Expression: "true", Roles: []string{"eks-edge"}}),
+		WithTransforms(Transform{Regex: "^eks-([a-z]+)-"}),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	node := getTestNode("n1", map[string]string{"eks.amazonaws.com/nodegroup": "eks-gpu-a100-2024-09-xyz"})
+	if err := h.EnsureRole(context.Background(), node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only source label values are transformed, rule roles are applied as is
+	if len(applied) != 2 || applied[rolePrefix+"gpu"] != "" || applied[rolePrefix+"eks-edge"] != "" {
+		t.Errorf("unexpected applied roles: %v", applied)
+	}
+
+	if _, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("a"), WithTransforms(Transform{})); err == nil {
+		t.Error("expected error for invalid transform")
+	}
+}
@@ -0,0 +1,130 @@
+package role
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mchmarny/rolesetter/pkg/metric"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// nodeRestrictionPrefix is the label namespace the NodeRestriction admission plugin
+	// prevents kubelets from setting on their own Node.
+	nodeRestrictionPrefix = "node-restriction.kubernetes.io/"
+	// kubeletManager is the field manager of the node's own kubelet.
+	kubeletManager = "kubelet"
+
+	// eventReasonUntrustedSource is the reason of the Node event recorded for an ignored untrusted source label
+	eventReasonUntrustedSource = "UntrustedSource"
+)
+
+var untrustedCounter = metric.NewCounter("node_role_untrusted_source_total",
+	"Total number of source label values ignored because they were not set by a trusted writer", "source")
+
+// Trust restricts the source labels that may drive roles to node-restriction.kubernetes.io/ ones,
+// which the node can't set itself, or ones written under a trusted field manager name.
+// The zero value trusts every source label.
+type Trust struct {
+	// Enabled only honors source labels in the node-restriction.kubernetes.io/ namespace,
+	// or labels written by one of the Managers.
+	Enabled bool
+	// Managers are the field manager names trusted to write source labels and override annotations.
+	// Clients pick the name, so a node credential can forge any of them; only the
+	// node-restriction.kubernetes.io/ namespace keeps a node from setting its own role.
+	// The kubelet can't be listed.
+	Managers []string
+}
+
+// Validate checks that the trusted managers are set and don't include the kubelet.
+func (t Trust) Validate() error {
+	for _, m := range t.Managers {
+		if m == "" {
+			return fmt.Errorf("trusted manager must not be empty")
+		}
+		if m == kubeletManager {
+			return fmt.Errorf("the %s field manager can't be trusted, it writes the node's own labels", kubeletManager)
+		}
+	}
+	return nil
+}
+
+// trusted reports whether the source label is honored on the node: it is in the
+// node-restriction.kubernetes.io/ namespace, or its managedFields show a trusted manager wrote it.
+func (t Trust) trusted(n *corev1.Node, key string) bool {
+	if !t.Enabled || strings.HasPrefix(key, nodeRestrictionPrefix) {
+		return true
+	}
+	return t.managedByTrusted(n, fieldsLabels, key)
+}
+
+// trustedAnnotation reports whether the override annotation is honored on the node:
+// its managedFields show a trusted manager wrote it. Annotations have no namespace the node can't set,
+// so with no managers they are never honored.
+func (t Trust) trustedAnnotation(n *corev1.Node, key string) bool {
+	return !t.Enabled || t.managedByTrusted(n, fieldsAnnotations, key)
+}
+
+// managedByTrusted reports whether one of the trusted managers owns the label or annotation key.
+func (t Trust) managedByTrusted(n *corev1.Node, field, key string) bool {
+	for _, m := range t.Managers {
+		if fieldManagedBy(n, m, field, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldManagedBy reports whether the field manager owns the key of the metadata field
+// (labels or annotations) on the node, with any operation.
+func fieldManagedBy(n *corev1.Node, manager, field, key string) bool {
+	for _, mf := range n.ManagedFields {
+		if mf.Manager != manager || mf.Subresource != "" || mf.FieldsV1 == nil {
+			continue
+		}
+		keys := map[string]bool{}
+		collectFieldKeys(decodeFields(decodeFields(mf.FieldsV1.Raw)[fieldsMetadata])[field], keys)
+		if keys[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedSources returns the source labels set on the node that may drive roles, in priority order.
+// Untrusted ones are reported and skipped, so the next source label is used in first mode.
+func (h *CacheResourceHandler) trustedSources(n *corev1.Node) []string {
+	if !h.trust.Enabled {
+		return h.sources
+	}
+	var res []string
+	for _, key := range h.sources {
+		if n.Labels[key] == "" {
+			continue
+		}
+		if !h.trust.trusted(n, key) {
+			h.reportUntrusted(n, key, n.Labels[key])
+			continue
+		}
+		res = append(res, key)
+		if h.mode == SourceModeFirst {
+			break
+		}
+	}
+	return res
+}
+
+// reportUntrusted records the security log, metric and Node event for an ignored untrusted
+// source label or override annotation.
+func (h *CacheResourceHandler) reportUntrusted(n *corev1.Node, key, value string) {
+	untrustedCounter.Increment(key)
+	h.logger.Named("security").Warn("ignoring untrusted source",
+		zap.String("node", n.Name),
+		zap.String("source", key),
+		zap.String("value", value),
+		zap.Strings("trustedManagers", h.trust.Managers),
+	)
+	h.eventf(n, corev1.EventTypeWarning, eventReasonUntrustedSource,
+		"Ignoring %s=%s, it was not set by a trusted writer", key, value)
+}
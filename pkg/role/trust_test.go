@@ -0,0 +1,99 @@
+package role
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mchmarny/rolesetter/pkg/logger"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTrust_Trusted(t *testing.T) {
+	restricted := nodeRestrictionPrefix + "pool"
+	node := withOwnedLabels(withOwnedLabels(getTestNode("n1", map[string]string{
+		"nodeGroup": "gpu",
+		"team":      "ml",
+		restricted:  "cpu",
+	}), "kubectl-label", "team"), kubeletManager, "nodeGroup")
+
+	tests := []struct {
+		name  string
+		trust Trust
+		key   string
+		want  bool
+	}{
+		{name: "disabled", key: "nodeGroup", want: true},
+		{name: "node restriction", trust: Trust{Enabled: true}, key: restricted, want: true},
+		{name: "no managers", trust: Trust{Enabled: true}, key: "team", want: false},
+		{name: "trusted manager", trust: Trust{Enabled: true, Managers: []string{"kubectl-label"}}, key: "team", want: true},
+		{name: "kubelet", trust: Trust{Enabled: true, Managers: []string{"kubectl-label"}}, key: "nodeGroup", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.trust.trusted(node, tt.key); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTrust_Validate(t *testing.T) {
+	if err := (Trust{Enabled: true, Managers: []string{"kubectl-label"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := (Trust{Managers: []string{kubeletManager}}).Validate(); err == nil {
+		t.Error("expected error for trusting the kubelet")
+	}
+	if err := (Trust{Managers: []string{""}}).Validate(); err == nil {
+		t.Error("expected error for empty manager")
+	}
+}
+
+func TestEnsureRole_UntrustedSource(t *testing.T) {
+	var applied map[string]string
+	patcher := func(_ context.Context, _ string, _ types.PatchType, data []byte, _ metav1.PatchOptions, _ ...string) (*corev1.Node, error) {
+		applied = getAppliedLabels(t, data)
+		return nil, nil
+	}
+	recorder := record.NewFakeRecorder(10)
+
+	h, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("nodeGroup", "team"),
+		WithTrust(Trust{Enabled: true, Managers: []string{"kubectl-label"}}),
+		WithRecorder(recorder),
+	)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	// The kubelet set nodeGroup itself, so the next source label is used in first mode
+	node := withOwnedLabels(withOwnedLabels(getTestNode("n1", map[string]string{
+		"nodeGroup": "gpu",
+		"team":      "ml",
+	}), kubeletManager, "nodeGroup"), "kubectl-label", "team")
+	if err := h.EnsureRole(context.Background(), node); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(applied) != 1 || applied[rolePrefix+"ml"] != "" {
+		t.Errorf("expected only the trusted role to be applied, got %v", applied)
+	}
+
+	select {
+	case e := <-recorder.Events:
+		if !strings.Contains(e, "Warning "+eventReasonUntrustedSource) || !strings.Contains(e, "nodeGroup=gpu") {
+			t.Errorf("unexpected event: %s", e)
+		}
+	default:
+		t.Error("expected an untrusted source event")
+	}
+
+	if _, err := NewCacheResourceHandler(patcher, logger.GetTestLogger(),
+		WithSources("nodeGroup"), WithTrust(Trust{Managers: []string{kubeletManager}})); err == nil {
+		t.Error("expected error for trusting the kubelet")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Check reports the health of a component; a nil error means healthy.
+type Check func() error
+
+// Health is a registry of named liveness and readiness checks.
+type Health struct {
+	mu        sync.RWMutex
+	liveness  map[string]Check
+	readiness map[string]Check
+}
+
+// NewHealth creates an empty Health registry. With no checks registered, every probe succeeds.
+func NewHealth() *Health {
+	return &Health{
+		liveness:  map[string]Check{},
+		readiness: map[string]Check{},
+	}
+}
+
+// AddLivenessCheck registers a check served on /livez and /healthz.
+// A failing liveness check means the process should be restarted.
+func (h *Health) AddLivenessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// AddReadinessCheck registers a check served on /readyz.
+// A failing readiness check means the process is running but not able to do its work.
+func (h *Health) AddReadinessCheck(name string, check Check) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// LivenessHandler returns the HTTP handler that runs the liveness checks.
+func (h *Health) LivenessHandler() http.Handler {
+	return h.handler(func() map[string]Check { return h.liveness })
+}
+
+// ReadinessHandler returns the HTTP handler that runs the readiness checks.
+func (h *Health) ReadinessHandler() http.Handler {
+	return h.handler(func() map[string]Check { return h.readiness })
+}
+
+// handler runs the checks and responds with 200 when all pass or 503 listing the failures.
+// The `verbose` query parameter lists the result of every check.
+func (h *Health) handler(checks func() map[string]Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.RLock()
+		all := checks()
+		registered := make(map[string]Check, len(all))
+		names := make([]string, 0, len(all))
+		for name, check := range all {
+			registered[name] = check
+			names = append(names, name)
+		}
+		h.mu.RUnlock()
+		sort.Strings(names)
+
+		_, verbose := r.URL.Query()["verbose"]
+
+		var b strings.Builder
+		failed := false
+		for _, name := range names {
+			if err := registered[name](); err != nil {
+				failed = true
+				fmt.Fprintf(&b, "[-]%s failed: %v\n", name, err)
+				continue
+			}
+			if verbose {
+				fmt.Fprintf(&b, "[+]%s ok\n", name)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if failed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprint(&b, "check failed\n")
+		} else {
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(&b, "ok\n")
+		}
+		_, _ = w.Write([]byte(b.String()))
+	})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHealth_Handlers(t *testing.T) {
+	h := NewHealth()
+	h.AddLivenessCheck("informer", func() error { return nil })
+	h.AddReadinessCheck("cache-synced", func() error { return nil })
+	h.AddReadinessCheck("watch", func() error { return errors.New("too many watch errors") })
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		url      string
+		wantCode int
+		wantBody []string
+	}{
+		{
+			name:     "liveness passes",
+			handler:  h.LivenessHandler(),
+			url:      "/livez",
+			wantCode: http.StatusOK,
+			wantBody: []string{"ok"},
+		},
+		{
+			name:     "readiness fails",
+			handler:  h.ReadinessHandler(),
+			url:      "/readyz",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{"[-]watch failed: too many watch errors"},
+		},
+		{
+			name:     "verbose lists passing checks",
+			handler:  h.ReadinessHandler(),
+			url:      "/readyz?verbose",
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: []string{"[+]cache-synced ok", "[-]watch failed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected %d, got %d", tt.wantCode, rec.Code)
+			}
+			body, _ := io.ReadAll(rec.Body)
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Errorf("expected body to contain %q, got %q", want, body)
+				}
+			}
+		})
+	}
+}
+
+func TestHealth_NoChecks(t *testing.T) {
+	h := NewHealth()
+	for _, handler := range []http.Handler{h.LivenessHandler(), h.ReadinessHandler()} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected 200 with no checks, got %d", rec.Code)
+		}
+	}
+}
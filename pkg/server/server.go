@@ -32,11 +32,19 @@ func WithPort(port int) Option {
 	}
 }
 
+// WithHealth sets the health check registry served on /livez, /healthz and /readyz.
+func WithHealth(health *Health) Option {
+	return func(s *server) {
+		s.health = health
+	}
+}
+
 // NewServer creates a new Server instance with the provided options.
 func NewServer(opts ...Option) Server {
 	s := &server{
 		logger: logger.GetLogger(), // default logger
 		port:   8080,
+		health: NewHealth(),
 	}
 
 	for _, opt := range opts {
@@ -50,6 +58,7 @@ func NewServer(opts ...Option) Server {
 type server struct {
 	logger *zap.Logger
 	port   int
+	health *Health
 }
 
 // Serve initializes and starts the HTTP server for metrics and health checks.
@@ -86,8 +95,14 @@ func (s *server) buildHandler(handlers map[string]http.Handler) http.Handler {
 		w.WriteHeader(http.StatusOK)
 	}
 
-	mux.HandleFunc("/healthz", okFunc)
-	mux.HandleFunc("/readyz", okFunc)
+	health := s.health
+	if health == nil {
+		health = NewHealth()
+	}
+
+	mux.Handle("/livez", health.LivenessHandler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler())
 	mux.HandleFunc("/", okFunc)
 
 	for path, handler := range handlers {
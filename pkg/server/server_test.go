@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -15,7 +16,7 @@ func TestBuildHandler_HealthAndReadyEndpoints(t *testing.T) {
 	ts := httptest.NewServer(handler)
 	defer ts.Close()
 
-	endpoints := []string{"/healthz", "/readyz", "/"}
+	endpoints := []string{"/healthz", "/livez", "/readyz", "/"}
 	for _, ep := range endpoints {
 		resp, err := http.Get(ts.URL + ep)
 		if err != nil {
@@ -28,6 +29,41 @@ func TestBuildHandler_HealthAndReadyEndpoints(t *testing.T) {
 	}
 }
 
+func TestBuildHandler_HealthChecks(t *testing.T) {
+	health := NewHealth()
+	health.AddLivenessCheck("informer", func() error { return errors.New("informer exited") })
+	health.AddReadinessCheck("cache-synced", func() error { return nil })
+
+	srv := &server{logger: logger.GetTestLogger(), port: 8080, health: health}
+	ts := httptest.NewServer(srv.buildHandler(nil))
+	defer ts.Close()
+
+	tests := map[string]int{
+		"/livez":   http.StatusServiceUnavailable,
+		"/healthz": http.StatusServiceUnavailable,
+		"/readyz":  http.StatusOK,
+	}
+	for ep, want := range tests {
+		resp, err := http.Get(ts.URL + ep)
+		if err != nil {
+			t.Fatalf("failed to GET %s: %v", ep, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("expected %d for %s, got %d", want, ep, resp.StatusCode)
+		}
+	}
+}
+
+func TestWithHealth_SetsHealth(t *testing.T) {
+	s := &server{}
+	h := NewHealth()
+	WithHealth(h)(s)
+	if s.health != h {
+		t.Error("WithHealth did not set health")
+	}
+}
+
 func TestBuildHandler_RegistersMetricsHandler(t *testing.T) {
 	logger := logger.GetTestLogger()
 	srv := &server{logger: logger, port: 8080}